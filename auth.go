@@ -0,0 +1,188 @@
+package go_websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 身份信息
+type Identity struct {
+	UserId   string                 //用户ID
+	SystemId string                 //系统ID
+	Groups   []string               //允许加入的组，"*"表示全部
+	Claims   map[string]interface{} //其他声明
+}
+
+// 是否允许加入组
+func (i *Identity) AllowGroup(group string) bool {
+	for _, g := range i.Groups {
+		if g == "*" || g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取声明
+func (i *Identity) GetClaim(key string) (interface{}, bool) {
+	if i.Claims == nil {
+		return nil, false
+	}
+	v, ok := i.Claims[key]
+	return v, ok
+}
+
+// 鉴权，在升级连接之前执行
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// 函数形式的鉴权
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// 鉴权错误，Status 为拒绝时返回的HTTP状态码
+type AuthError struct {
+	Status int
+	Msg    string
+}
+
+func NewAuthError(status int, msg string) *AuthError {
+	return &AuthError{
+		Status: status,
+		Msg:    msg,
+	}
+}
+
+func (e *AuthError) Error() string {
+	return e.Msg
+}
+
+var (
+	ErrUnauthorized = NewAuthError(http.StatusUnauthorized, "unauthorized")
+	ErrTokenExpired = NewAuthError(http.StatusUnauthorized, "token expired")
+	ErrInvalidSign  = NewAuthError(http.StatusUnauthorized, "invalid sign")
+)
+
+// 拒绝请求
+func writeAuthError(w http.ResponseWriter, err error) {
+	status := http.StatusUnauthorized
+	msg := err.Error()
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		status = authErr.Status
+		msg = authErr.Msg
+	}
+	http.Error(w, msg, status)
+}
+
+// 从请求中获取令牌，依次读取 Authorization: Bearer 头和 query 参数
+func TokenFromRequest(r *http.Request, query string) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if query != "" {
+		return r.URL.Query().Get(query)
+	}
+	return ""
+}
+
+// Bearer令牌鉴权
+type BearerAuthenticator struct {
+	TokenQuery string                                //浏览器无法设置请求头时，从该query参数读取令牌
+	Verify     func(token string) (*Identity, error) //校验令牌
+}
+
+func NewBearerAuthenticator(verify func(token string) (*Identity, error)) *BearerAuthenticator {
+	return &BearerAuthenticator{
+		TokenQuery: "access_token",
+		Verify:     verify,
+	}
+}
+
+func (a *BearerAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := TokenFromRequest(r, a.TokenQuery)
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+	return a.Verify(token)
+}
+
+// HMAC签名query鉴权
+//
+// 参数：user_id、system_id、groups（逗号分隔）、expires（unix秒）、sign
+// sign = hex(HMAC-SHA256(secret, "user_id=...&system_id=...&groups=...&expires=..."))，各值经过 url.QueryEscape
+type HMACAuthenticator struct {
+	secret []byte
+}
+
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secret: secret}
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	query := r.URL.Query()
+
+	sign := query.Get("sign")
+	expires := query.Get("expires")
+	if sign == "" || expires == "" {
+		return nil, ErrUnauthorized
+	}
+
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if time.Now().Unix() > exp {
+		return nil, ErrTokenExpired
+	}
+
+	expected := a.sign(query.Get("user_id"), query.Get("system_id"), query.Get("groups"), expires)
+	got, err := hex.DecodeString(sign)
+	if err != nil || !hmac.Equal(got, expected) {
+		return nil, ErrInvalidSign
+	}
+
+	identity := &Identity{
+		UserId:   query.Get("user_id"),
+		SystemId: query.Get("system_id"),
+	}
+	if groups := query.Get("groups"); groups != "" {
+		identity.Groups = strings.Split(groups, ",")
+	}
+	return identity, nil
+}
+
+// 生成签名后的query参数
+func (a *HMACAuthenticator) SignQuery(identity *Identity, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	groups := strings.Join(identity.Groups, ",")
+
+	query := url.Values{}
+	query.Set("user_id", identity.UserId)
+	query.Set("system_id", identity.SystemId)
+	query.Set("groups", groups)
+	query.Set("expires", exp)
+	query.Set("sign", hex.EncodeToString(a.sign(identity.UserId, identity.SystemId, groups, exp)))
+	return query
+}
+
+func (a *HMACAuthenticator) sign(userId, systemId, groups, expires string) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte("user_id=" + url.QueryEscape(userId) +
+		"&system_id=" + url.QueryEscape(systemId) +
+		"&groups=" + url.QueryEscape(groups) +
+		"&expires=" + url.QueryEscape(expires)))
+	return mac.Sum(nil)
+}
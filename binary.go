@@ -0,0 +1,190 @@
+package go_websocket
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrInvalidFrame = errors.New("invalid binary frame")
+)
+
+// 可指定消息类型的请求或响应，未实现时为文本消息
+type IMessageType interface {
+	GetMessageType() int
+}
+
+// 二进制信封，负责二进制帧与路由、负载之间的转换
+type BinaryEnvelope interface {
+	Decode(data []byte) (*BinaryRequest, error)
+	Encode(res *BinaryResponse) ([]byte, error)
+}
+
+// 二进制请求
+type BinaryRequest struct {
+	Id      string //请求ID
+	Url     string //路由
+	Payload []byte //负载
+}
+
+func (r *BinaryRequest) GetId() string {
+	return r.Id
+}
+
+func (r *BinaryRequest) GetUrl() string {
+	return r.Url
+}
+
+func (r *BinaryRequest) GetParams() interface{} {
+	return r.Payload
+}
+
+func (r *BinaryRequest) GetRawParams() []byte {
+	return r.Payload
+}
+
+func (r *BinaryRequest) GetMessageType() int {
+	return websocket.BinaryMessage
+}
+
+// 二进制响应
+type BinaryResponse struct {
+	Id      string //对应的请求ID
+	Url     string //对应的请求路由
+	Type    string //响应类型，reply 或 push
+	Code    int
+	Msg     string
+	Payload []byte //负载
+}
+
+func NewBinaryResponse(code int, msg string, payload []byte) *BinaryResponse {
+	return &BinaryResponse{
+		Code:    code,
+		Msg:     msg,
+		Payload: payload,
+	}
+}
+
+func NewOkBinaryRes(payload []byte) *BinaryResponse {
+	return &BinaryResponse{
+		Code:    200,
+		Msg:     "成功",
+		Payload: payload,
+	}
+}
+
+// 关联请求
+func (r *BinaryResponse) SetReply(id string, url string) {
+	r.Id = id
+	r.Url = url
+	r.Type = ResponseTypeReply
+}
+
+// 标记为推送
+func (r *BinaryResponse) SetPush() {
+	r.Id = ""
+	r.Type = ResponseTypePush
+}
+
+func (r *BinaryResponse) GetMessageType() int {
+	return websocket.BinaryMessage
+}
+
+// 使用默认信封编码，客户端发送时使用管理器配置的信封
+func (r *BinaryResponse) GetBytes() ([]byte, error) {
+	return LengthPrefixEnvelope{}.Encode(r)
+}
+
+// 文本响应转为二进制响应，数据为 []byte 时直接作为负载，否则编码为JSON
+func binaryResponseFrom(res *ClientResponse) (*BinaryResponse, error) {
+	br := &BinaryResponse{
+		Id:   res.Id,
+		Url:  res.Url,
+		Type: res.Type,
+		Code: res.Code,
+		Msg:  res.Msg,
+	}
+	switch d := res.Data.(type) {
+	case nil:
+	case []byte:
+		br.Payload = d
+	default:
+		payload, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		br.Payload = payload
+	}
+	return br, nil
+}
+
+// 消息类型，未实现 IMessageType 时为文本消息
+func messageTypeOf(v interface{}) int {
+	if m, ok := v.(IMessageType); ok {
+		return m.GetMessageType()
+	}
+	return websocket.TextMessage
+}
+
+// 默认的二进制信封，字段按长度前缀依次排列，长度为大端序 uint16
+//
+// 请求：url | id | payload
+//
+// 响应：type | code | url | id | msg | payload，code 为大端序 uint16
+type LengthPrefixEnvelope struct{}
+
+func (LengthPrefixEnvelope) Decode(data []byte) (*BinaryRequest, error) {
+	url, data, err := readPrefixed(data)
+	if err != nil {
+		return nil, err
+	}
+	id, data, err := readPrefixed(data)
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryRequest{
+		Id:      string(id),
+		Url:     string(url),
+		Payload: data,
+	}, nil
+}
+
+func (LengthPrefixEnvelope) Encode(res *BinaryResponse) ([]byte, error) {
+	if res.Code < 0 || res.Code > 0xffff {
+		return nil, ErrInvalidFrame
+	}
+	buf := make([]byte, 0, 2*6+len(res.Type)+len(res.Url)+len(res.Id)+len(res.Msg)+len(res.Payload))
+	var err error
+	if buf, err = appendPrefixed(buf, res.Type); err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(res.Code))
+	for _, s := range []string{res.Url, res.Id, res.Msg} {
+		if buf, err = appendPrefixed(buf, s); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, res.Payload...), nil
+}
+
+func readPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, ErrInvalidFrame
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, ErrInvalidFrame
+	}
+	return data[2 : 2+n], data[2+n:], nil
+}
+
+func appendPrefixed(buf []byte, s string) ([]byte, error) {
+	if len(s) > 0xffff {
+		return nil, ErrInvalidFrame
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...), nil
+}
@@ -0,0 +1,149 @@
+package go_websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 编码请求帧
+func binaryFrame(url, id string, payload []byte) []byte {
+	buf, _ := appendPrefixed(nil, url)
+	buf, _ = appendPrefixed(buf, id)
+	return append(buf, payload...)
+}
+
+// 解码响应帧
+func decodeBinaryResponse(t *testing.T, data []byte) *BinaryResponse {
+	t.Helper()
+	res := &BinaryResponse{}
+	typ, data, err := readPrefixed(data)
+	if err != nil || len(data) < 2 {
+		t.Fatalf("invalid response frame: %v", err)
+	}
+	res.Type = string(typ)
+	res.Code = int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	for _, s := range []*string{&res.Url, &res.Id, &res.Msg} {
+		var v []byte
+		if v, data, err = readPrefixed(data); err != nil {
+			t.Fatalf("invalid response frame: %v", err)
+		}
+		*s = string(v)
+	}
+	res.Payload = data
+	return res
+}
+
+func TestLengthPrefixDecode(t *testing.T) {
+	req, err := LengthPrefixEnvelope{}.Decode(binaryFrame("/img", "1", []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Url != "/img" || req.Id != "1" || !bytes.Equal(req.Payload, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if req, err := (LengthPrefixEnvelope{}).Decode(binaryFrame("/img", "", nil)); err != nil || req.Id != "" || len(req.Payload) != 0 {
+		t.Fatalf("empty id and payload: %+v %v", req, err)
+	}
+
+	for name, frame := range map[string][]byte{
+		"empty":              {},
+		"short url length":   {0},
+		"url longer":         {0, 5, '/', 'i'},
+		"missing id":         binaryFrame("/img", "", nil)[:6],
+		"short id length":    append(binaryFrame("/img", "", nil)[:6], 0),
+		"id longer":          append(binaryFrame("/img", "", nil)[:6], 0xff, 0xff, 'x'),
+		"max length no data": {0xff, 0xff},
+	} {
+		if _, err := (LengthPrefixEnvelope{}).Decode(frame); err != ErrInvalidFrame {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestLengthPrefixEncode(t *testing.T) {
+	res := &BinaryResponse{Id: "1", Url: "/img", Type: ResponseTypeReply, Code: 200, Msg: "ok", Payload: []byte{9}}
+	data, err := LengthPrefixEnvelope{}.Encode(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeBinaryResponse(t, data); got.Id != "1" || got.Url != "/img" || got.Type != ResponseTypeReply ||
+		got.Code != 200 || got.Msg != "ok" || !bytes.Equal(got.Payload, []byte{9}) {
+		t.Fatalf("unexpected response %+v", got)
+	}
+
+	//字段最长 65535 字节，状态码为 uint16
+	long := strings.Repeat("x", 0x10000)
+	for name, r := range map[string]*BinaryResponse{
+		"negative code": {Code: -1},
+		"large code":    {Code: 0x10000},
+		"long type":     {Type: long},
+		"long url":      {Url: long},
+		"long id":       {Id: long},
+		"long msg":      {Msg: long},
+	} {
+		if _, err := (LengthPrefixEnvelope{}).Encode(r); err != ErrInvalidFrame {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := (LengthPrefixEnvelope{}).Encode(&BinaryResponse{Msg: long[1:], Payload: []byte(long)}); err != nil {
+		t.Fatalf("max field length: %v", err)
+	}
+}
+
+func TestSendReplyBinary(t *testing.T) {
+	cm := NewClientManage()
+	c := NewClient("1", "s", nil, cm)
+	req := &BinaryRequest{Id: "1", Url: "/img"}
+
+	tests := []struct {
+		res     *ClientResponse
+		payload string
+	}{
+		{NewOkClientRes(map[string]int{"a": 1}), `{"a":1}`},
+		{NewOkClientRes([]byte{1, 2}), "\x01\x02"},
+		{NewOkClientRes(nil), ""},
+		{NewErrClientRes("bad", nil), ""},
+	}
+	for _, tt := range tests {
+		if err := c.SendReply(req, tt.res); err != nil {
+			t.Fatal(err)
+		}
+		m := <-c.send
+		if m.msgType != websocket.BinaryMessage {
+			t.Fatalf("message type %d", m.msgType)
+		}
+		got := decodeBinaryResponse(t, m.data)
+		if got.Id != "1" || got.Url != "/img" || got.Type != ResponseTypeReply || got.Code != tt.res.Code ||
+			got.Msg != tt.res.Msg || string(got.Payload) != tt.payload {
+			t.Fatalf("unexpected response %+v", got)
+		}
+	}
+
+	//无法编码为JSON的数据
+	if err := c.SendReply(req, NewOkClientRes(make(chan int))); err == nil {
+		t.Fatal("SendReply succeeded")
+	}
+}
+
+func TestBinaryBadFrame(t *testing.T) {
+	cm := NewClientManage()
+	u := newTestServer(t, cm)
+	conn, _ := dialClient(t, cm, u)
+
+	//无法解析的二进制请求同样回复二进制错误响应
+	conn.WriteMessage(websocket.BinaryMessage, []byte{0, 9})
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msgType, data, err := conn.ReadMessage()
+	if err != nil || msgType != websocket.BinaryMessage {
+		t.Fatalf("ReadMessage: %d %v", msgType, err)
+	}
+	if res := decodeBinaryResponse(t, data); res.Code != 400 {
+		t.Fatalf("unexpected response %+v", res)
+	}
+}
@@ -0,0 +1,248 @@
+package go_websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBrokerClosed = errors.New("broker closed")
+)
+
+// 消息主题，对应发送目标的类型
+type Topic string
+
+const (
+	TopicClient    Topic = "client"    //指定客户端
+	TopicGroup     Topic = "group"     //组
+	TopicSystem    Topic = "system"    //系统
+	TopicUser      Topic = "user"      //用户
+	TopicBroadcast Topic = "broadcast" //所有客户端
+)
+
+// 所有主题
+var Topics = []Topic{TopicClient, TopicGroup, TopicSystem, TopicUser, TopicBroadcast}
+
+// 节点间转发的消息
+type BrokerMessage struct {
+	Node      string   `json:"node"`                //发布节点
+	Topic     Topic    `json:"topic"`               //主题
+	Clients   []string `json:"clients,omitempty"`   //客户端ID
+	Groups    []string `json:"groups,omitempty"`    //组
+	Systems   []string `json:"systems,omitempty"`   //系统
+	Users     []string `json:"users,omitempty"`     //用户
+	Broadcast bool     `json:"broadcast,omitempty"` //所有客户端
+	Binary    bool     `json:"binary,omitempty"`    //是否为二进制消息
+	Data      []byte   `json:"data"`                //消息内容
+}
+
+// 发送目标
+func (m *BrokerMessage) Target() Target {
+	return Target{
+		Clients:   m.Clients,
+		Groups:    m.Groups,
+		Systems:   m.Systems,
+		Users:     m.Users,
+		Broadcast: m.Broadcast,
+	}
+}
+
+// 收到消息的回调
+type BrokerHandler func(msg *BrokerMessage)
+
+// 消息总线，用于集群内节点间转发消息
+type Broker interface {
+	//发布消息到 msg.Topic
+	Publish(ctx context.Context, msg *BrokerMessage) error
+	//订阅主题，订阅建立后返回，ctx 取消后退订
+	Subscribe(ctx context.Context, topics []Topic, handler BrokerHandler) error
+	//关闭
+	Close() error
+}
+
+// 默认节点ID，主机名加进程ID
+func DefaultNodeId() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// 节点ID
+func (cm *ClientManage) GetNodeId() string {
+	return cm.opts.NodeId
+}
+
+// 订阅其他节点转发的消息，ctx 取消后退订
+func (cm *ClientManage) subscribe(ctx context.Context) error {
+	return cm.opts.Broker.Subscribe(ctx, Topics, func(msg *BrokerMessage) {
+		defer func() {
+			if err := recover(); err != nil {
+				Log.Error(ctx, "BrokerHandler Panic ", err)
+			}
+		}()
+
+		//忽略自己发布的消息
+		if msg.Node == cm.opts.NodeId {
+			return
+		}
+		if msg.Binary {
+			cm.deliverBinary(msg.Data, msg.Target())
+		} else {
+			cm.deliver(msg.Data, msg.Target())
+		}
+	})
+}
+
+// 订阅失败时的重试间隔
+const (
+	subscribeRetryMin = time.Second
+	subscribeRetryMax = 30 * time.Second
+)
+
+// 订阅其他节点转发的消息，失败时按指数退避重试，直到成功或 ctx 取消
+func (cm *ClientManage) subscribeRetry(ctx context.Context) {
+	interval := subscribeRetryMin
+	for {
+		err := cm.subscribe(ctx)
+		if err == nil {
+			return
+		}
+		Log.Error(ctx, "Broker Subscribe Error ", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > subscribeRetryMax {
+			interval = subscribeRetryMax
+		}
+	}
+}
+
+// 转发给其他节点，本节点不存在的客户端、组、系统、广播由其他节点投递
+//
+// 设置了 Filter 的发送不转发
+func (cm *ClientManage) forward(data []byte, target Target, binary bool, report *DeliveryReport) {
+	broker := cm.opts.Broker
+	if broker == nil || target.Filter != nil {
+		return
+	}
+
+	msg := &BrokerMessage{
+		Node:      cm.opts.NodeId,
+		Clients:   report.Remote,
+		Groups:    target.Groups,
+		Systems:   target.Systems,
+		Users:     target.Users,
+		Broadcast: target.Broadcast,
+		Binary:    binary,
+		Data:      data,
+	}
+	//组合目标发布到范围最大的主题，只发布一次
+	switch {
+	case msg.Broadcast:
+		msg.Topic = TopicBroadcast
+	case len(msg.Groups) > 0:
+		msg.Topic = TopicGroup
+	case len(msg.Systems) > 0:
+		msg.Topic = TopicSystem
+	case len(msg.Users) > 0:
+		msg.Topic = TopicUser
+	case len(msg.Clients) > 0:
+		msg.Topic = TopicClient
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cm.opts.WriteDeadline)
+	defer cancel()
+	if err := broker.Publish(ctx, msg); err != nil {
+		Log.Error(ctx, "Broker Publish Error ", err)
+		report.ForwardError = err.Error()
+		return
+	}
+	report.Forwarded = true
+}
+
+// 内存消息总线，同一进程内的多个管理器共用，用于测试或单机多实例
+type MemoryBroker struct {
+	subs   map[int]*memorySubscription
+	nextId int
+	closed bool
+	lock   sync.RWMutex
+}
+
+type memorySubscription struct {
+	topics  map[Topic]struct{}
+	handler BrokerHandler
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subs: make(map[int]*memorySubscription),
+	}
+}
+
+// 发布，同步调用订阅者的回调
+func (b *MemoryBroker) Publish(ctx context.Context, msg *BrokerMessage) error {
+	b.lock.RLock()
+	if b.closed {
+		b.lock.RUnlock()
+		return ErrBrokerClosed
+	}
+	handlers := make([]BrokerHandler, 0, len(b.subs))
+	for _, s := range b.subs {
+		if _, ok := s.topics[msg.Topic]; ok {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	b.lock.RUnlock()
+
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topics []Topic, handler BrokerHandler) error {
+	s := &memorySubscription{
+		topics:  make(map[Topic]struct{}, len(topics)),
+		handler: handler,
+	}
+	for _, t := range topics {
+		s.topics[t] = struct{}{}
+	}
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return ErrBrokerClosed
+	}
+	id := b.nextId
+	b.nextId++
+	b.subs[id] = s
+	b.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.lock.Lock()
+		delete(b.subs, id)
+		b.lock.Unlock()
+	}()
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	b.subs = make(map[int]*memorySubscription)
+	return nil
+}
@@ -0,0 +1,78 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 发布订阅消息总线，每个主题对应一个频道 prefix:topic
+type RedisBroker struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// prefix 为空时使用 "ws"
+func NewRedisBroker(client redis.UniversalClient, prefix string) *RedisBroker {
+	if prefix == "" {
+		prefix = "ws"
+	}
+	return &RedisBroker{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (b *RedisBroker) channel(topic Topic) string {
+	return b.prefix + ":" + string(topic)
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, msg *BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel(msg.Topic), data).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topics []Topic, handler BrokerHandler) error {
+	channels := make([]string, 0, len(topics))
+	for _, t := range topics {
+		channels = append(channels, b.channel(t))
+	}
+
+	ps := b.client.Subscribe(ctx, channels...)
+	//等待订阅建立
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return err
+	}
+
+	go func() {
+		defer ps.Close()
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				msg := &BrokerMessage{}
+				if err := json.Unmarshal([]byte(m.Payload), msg); err != nil {
+					Log.Error(ctx, "RedisBroker Unmarshal Error ", err)
+					continue
+				}
+				handler(msg)
+			}
+		}
+	}()
+	return nil
+}
+
+// 关闭，不关闭传入的 Redis 客户端
+func (b *RedisBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 读取一条推送
+func readPush(t *testing.T, conn *websocket.Conn) ClientResponse {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ClientResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRedisBrokerForward(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	cm1 := NewClientManage(WithNodeId("n1"), WithBroker(NewRedisBroker(rdb, "")))
+	cm2 := NewClientManage(WithNodeId("n2"), WithBroker(NewRedisBroker(rdb, "")))
+	for _, cm := range []*ClientManage{cm1, cm2} {
+		if err := cm.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cm.Shutdown(context.Background())
+	}
+	if err := cm1.Start(); err != ErrManageStarted {
+		t.Fatalf("second Start: %v", err)
+	}
+
+	u := serveTest(t, cm2)
+	conn, _, err := websocket.DefaultDialer.Dial(u+"?group=g1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, 3*time.Second, func() bool {
+		return cm2.GetClientCount() == 1
+	})
+
+	msg, _ := NewOkClientRes("group").GetBytes()
+	report := cm1.SendGroupMsg(msg, "g1")
+	if !report.Forwarded {
+		t.Fatalf("not forwarded: %+v", report)
+	}
+	if res := readPush(t, conn); res.Data != "group" {
+		t.Fatalf("unexpected push %+v", res)
+	}
+
+	id := cm2.GetClientList()[0]
+	msg, _ = NewOkClientRes("client").GetBytes()
+	cm1.SendClientMsg(msg, id)
+	if res := readPush(t, conn); res.Data != "client" {
+		t.Fatalf("unexpected push %+v", res)
+	}
+}
+
+func TestStartSubscribeError(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	mr.Close()
+	cm := NewClientManage(WithBroker(NewRedisBroker(rdb, "")))
+	if err := cm.Start(); err == nil {
+		t.Fatal("Start succeeded without redis")
+	}
+}
+
+func TestRunRetriesSubscribe(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	mr.Close()
+	cm := NewClientManage(WithNodeId("n1"), WithBroker(NewRedisBroker(rdb, "")))
+	go cm.Run()
+	defer cm.Shutdown(context.Background())
+
+	time.Sleep(100 * time.Millisecond)
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return len(mr.PubSubChannels("")) == len(Topics)
+	})
+}
@@ -2,7 +2,6 @@ package go_websocket
 
 import (
 	"context"
-	"errors"
 	"github.com/gorilla/websocket"
 	"sync"
 	"time"
@@ -15,19 +14,63 @@ type Client struct {
 	systemId     string              //系统ID，该客户端属于哪个系统
 	groups       map[string]struct{} //组，该客户端加入的组
 	groupsLock   sync.RWMutex        //组锁
-	send         chan []byte         //发送消息通道
+	send         chan *outMessage    //发送消息通道
+	identity     *Identity           //身份信息，未配置鉴权时为空
+	subprotocol  *Subprotocol        //协商的子协议
+	ctx          context.Context     //上下文，读循环退出时取消
+	cancel       context.CancelFunc
+	jobs         chan IRequest  //无序请求队列
+	orderedJobs  chan IRequest  //有序请求队列
+	closing      bool           //是否正在关闭
+	stateLock    sync.Mutex     //状态锁
+	inflight     sync.WaitGroup //处理中的请求
+	closeOnce    sync.Once
+	closeCh      chan []byte   //关闭帧
+	registered   bool          //是否在管理器索引中，由管理器 indexLock 保护
+	done         chan struct{} //从管理器删除后关闭
+	doneOnce     sync.Once
+	kickOnce     sync.Once
+	slowKicked   int32              //是否因慢消费被断开
+	resFormatFn  ResponseFormatFunc //单独的响应格式化方法
+	codec        Codec              //编解码器，为空时使用格式化方法和二进制信封
+	compress     int32              //是否压缩，协商压缩后默认开启
+	wire         *countingConn      //协商压缩时的底层连接，用于统计
+	compression  compressionCounter //压缩统计
+	presenceLock sync.Mutex         //在线记录同步锁
+	session      *session           //可恢复的会话，未开启会话恢复时为空
+	detached     int32              //连接已断开，会话等待恢复
+	hooks        *hookQueue         //回调队列
+	replay       replayGate         //补发离线消息期间暂存实时消息
+	connectedAt  time.Time          //连接时间
+	closeCode    int                //关闭码，由 stateLock 保护
+	closeReason  string             //关闭原因
 }
 
 func NewClient(id string, systemId string, conn *websocket.Conn, clientMange *ClientManage) *Client {
-	return &Client{
+	c := &Client{
 		id:           id,
 		conn:         conn,
 		clientManage: clientMange,
 		systemId:     systemId,
 		groups:       make(map[string]struct{}),
 		groupsLock:   sync.RWMutex{},
-		send:         make(chan []byte, 256),
+		send:         make(chan *outMessage, clientMange.opts.SendBufferSize),
+		closeCh:      make(chan []byte, 1),
+		done:         make(chan struct{}),
+		hooks:        &hookQueue{},
+		connectedAt:  time.Now(),
 	}
+	c.ctx, c.cancel = context.WithCancel(newClientContext(context.Background(), c))
+	if c.concurrent() {
+		c.jobs = make(chan IRequest, clientMange.opts.QueueSize)
+		c.orderedJobs = make(chan IRequest, clientMange.opts.QueueSize)
+	}
+	return c
+}
+
+// 上下文，客户端断开时取消
+func (c *Client) Context() context.Context {
+	return c.ctx
 }
 
 // 客户端ID
@@ -40,6 +83,78 @@ func (c *Client) GetSystemId() string {
 	return c.systemId
 }
 
+// 身份信息，未配置鉴权时为空
+func (c *Client) GetIdentity() *Identity {
+	return c.identity
+}
+
+// 设置身份信息
+func (c *Client) SetIdentity(identity *Identity) {
+	c.identity = identity
+}
+
+// 用户ID
+func (c *Client) GetUserId() string {
+	if c.identity == nil {
+		return ""
+	}
+	return c.identity.UserId
+}
+
+// 协商的子协议名
+func (c *Client) GetSubprotocol() string {
+	if c.subprotocol == nil {
+		return ""
+	}
+	return c.subprotocol.Name
+}
+
+// 请求格式化方法，优先使用子协议配置
+func (c *Client) requestFormatFunc() RequestFormatFunc {
+	if c.subprotocol != nil && c.subprotocol.ReqFormatFn != nil {
+		return c.subprotocol.ReqFormatFn
+	}
+	return c.clientManage.reqFormatFn
+}
+
+// 设置该客户端单独的响应格式化方法，设置后群发时单独格式化，不共用预编码消息
+func (c *Client) SetResponseFormatFunc(fn ResponseFormatFunc) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.resFormatFn = fn
+}
+
+// 是否有单独的响应格式化方法
+func (c *Client) hasOwnResponseFormatFunc() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.resFormatFn != nil
+}
+
+// 响应格式化方法，优先使用客户端、子协议配置
+func (c *Client) responseFormatFunc() ResponseFormatFunc {
+	c.stateLock.Lock()
+	fn := c.resFormatFn
+	c.stateLock.Unlock()
+	if fn != nil {
+		return fn
+	}
+	if c.subprotocol != nil && c.subprotocol.ResFormatFn != nil {
+		return c.subprotocol.ResFormatFn
+	}
+	return c.clientManage.resFormatFn
+}
+
+// 响应编码，返回编码结果和消息类型
+func (c *Client) encodeResponse(res IResponse) ([]byte, int, error) {
+	return c.clientManage.encodeResponse(c, c.subprotocol, c.codec, res)
+}
+
+// 编解码器，未选择时为空
+func (c *Client) GetCodec() Codec {
+	return c.codec
+}
+
 // 所有组
 func (c *Client) GetGroups() []string {
 	c.groupsLock.RLock()
@@ -53,6 +168,14 @@ func (c *Client) GetGroups() []string {
 	return list
 }
 
+// 是否在组内
+func (c *Client) InGroup(group string) bool {
+	c.groupsLock.RLock()
+	defer c.groupsLock.RUnlock()
+	_, ok := c.groups[group]
+	return ok
+}
+
 // 加入组
 func (c *Client) AddGroup(groups ...string) {
 	if len(groups) <= 0 {
@@ -81,136 +204,335 @@ func (c *Client) DelGroup(groups ...string) {
 func (c *Client) SendMsg(msg []byte) error {
 	defer func() {
 		if err := recover(); err != nil {
-			Log.Error(context.Background(), "SendMsg Panic ", err)
+			Log.Error(c.ctx, "SendMsg Panic ", err)
 		}
 	}()
 
-	res, err := c.clientManage.resFormatFn(c, msg)
+	res, err := c.responseFormatFunc()(c, msg)
 	if err != nil {
-		Log.Error(context.Background(), "resFormatFn Error ", err)
+		Log.Error(c.ctx, "resFormatFn Error ", err)
 		return err
 	}
 
+	return c.SendPush(res)
+}
+
+// 发送推送，响应会被标记为推送
+func (c *Client) SendPush(res IResponse) error {
+	if p, ok := res.(IPushResponse); ok {
+		p.SetPush()
+	}
 	return c.SendResponse(res)
 }
 
-// 发送响应
+// 发送响应，客户端已断开时返回 ErrClientClosed
+//
+// 开启会话恢复时可编号的响应会带上序号并缓存，断开等待恢复期间只缓存
 func (c *Client) SendResponse(res IResponse) error {
-	defer func() {
-		if err := recover(); err != nil {
-			Log.Error(context.Background(), "SendResponse Panic ", err)
-		}
-	}()
+	if c.IsClosed() {
+		return ErrClientClosed
+	}
+	if held, err := c.holdLive(func() error { return c.sendResponse(res) }); held {
+		return err
+	}
+	return c.sendResponse(res)
+}
 
-	bytes, err := res.GetBytes()
+// 编码并发送响应，不经过补发暂存
+func (c *Client) sendResponse(res IResponse) error {
+	if _, ok := res.(ISeqResponse); ok && c.session != nil {
+		return c.session.send(c, res)
+	}
+
+	bytes, msgType, err := c.encodeResponse(res)
 	if err != nil {
-		Log.Error(context.Background(), "GetBytes Error ", err)
+		Log.Error(c.ctx, "EncodeResponse Error ", err)
 		return err
 	}
 
+	return c.enqueue(newOutMessage(msgType, bytes))
+}
+
+// 发送二进制消息，数据原样发送，不经过二进制信封
+func (c *Client) SendBinary(data []byte) error {
+	if c.IsClosed() {
+		return ErrClientClosed
+	}
+	return c.enqueueLive(newOutMessage(websocket.BinaryMessage, data))
+}
+
+// 是否已从管理器删除
+func (c *Client) IsClosed() bool {
 	select {
-	case c.send <- bytes:
+	case <-c.done:
+		return true
+	default:
+		return false
 	}
+}
 
-	return nil
+// 标记为已关闭，发送通道不会被关闭，等待发送的协程通过 done 退出
+func (c *Client) markClosed() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
 }
 
 // 读循环
 func (c *Client) ReadLoop() {
 	defer func() {
 		if err := recover(); err != nil {
-			Log.Error(context.Background(), "ReadLoop Panic ", err)
+			Log.Error(c.ctx, "ReadLoop Panic ", err)
 		}
 	}()
 
+	//开启会话恢复时异常断开的客户端保留到恢复窗口结束
+	var readErr error
 	defer func() {
-		c.clientManage.UnRegister(c)
+		c.cancel()
+		if !c.clientManage.detachSession(c, readErr) {
+			c.clientManage.UnRegister(c)
+		}
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(ReadLimit)
-	c.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+	opts := c.clientManage.opts
+
+	c.conn.SetReadLimit(opts.ReadLimit)
+	c.conn.SetReadDeadline(time.Now().Add(opts.ReadDeadline))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(ReadDeadline))
+		c.conn.SetReadDeadline(time.Now().Add(opts.ReadDeadline))
 		return nil
 	})
 
+	if c.concurrent() {
+		c.startWorkers()
+	}
+
 	for {
-		_, msg, err := c.conn.ReadMessage()
+		msgType, msg, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				Log.Error(context.Background(), "ReadMessage Error ", err)
+			readErr = err
+			if ce, ok := err.(*websocket.CloseError); ok {
+				c.setCloseStatus(ce.Code, ce.Text)
+			} else {
+				c.setCloseStatus(websocket.CloseAbnormalClosure, err.Error())
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				Log.Error(c.ctx, "ReadMessage Error ", err)
+				c.clientManage.onError(c, err)
 			}
 			return
 		}
 
-		if c.clientManage.reqFormatFn == nil {
-			c.clientManage.reqFormatFn = c.clientManage.DefaultRequestFormatFunc()
-		}
-
-		err = c.ProcessMessage(msg)
+		err = c.ProcessMessage(msgType, msg)
 		if err != nil {
-			Log.Error(context.Background(), "ProcessMessage Error ", err)
+			Log.Error(c.ctx, "ProcessMessage Error ", err)
+			c.clientManage.onError(c, err)
 		}
 	}
 }
 
-// 处理消息
-func (c *Client) ProcessMessage(msg []byte) error {
-	req, err := c.clientManage.reqFormatFn(c, msg)
+// 处理消息，出错时给客户端返回错误响应
+//
+// 选择了编解码器时使用编解码器解析，否则二进制消息通过二进制信封解析路由，
+// 其余消息使用请求格式化方法。
+// 启用并发处理时请求进入队列由工作协程处理，否则直接处理
+func (c *Client) ProcessMessage(msgType int, msg []byte) error {
+	c.clientManage.onMessage(c, msgType, msg)
+
+	req, err := c.decodeRequest(msgType, msg)
 	if err != nil {
+		var bad IRequest
+		if c.codec == nil && msgType == websocket.BinaryMessage {
+			//二进制请求无法解析时同样回复二进制响应
+			bad = &BinaryRequest{}
+		}
+		c.SendError(bad, ErrBadRequest.WithDetails(err.Error()))
 		return err
 	}
 
-	handler, ok := WsClientHandler.GetHandler(req.GetUrl())
-	if !ok {
-		return errors.New(req.GetUrl() + " handler not found")
+	//可靠发送的确认直接处理，不进入路由表
+	if route := c.clientManage.opts.AckRoute; len(route) > 0 && req.GetUrl() == route {
+		return c.clientManage.ack(c, req)
+	}
+
+	if c.concurrent() {
+		return c.enqueueRequest(req)
+	}
+	return c.HandleRequest(req)
+}
+
+// 解析请求
+func (c *Client) decodeRequest(msgType int, msg []byte) (IRequest, error) {
+	if c.codec != nil {
+		return c.codec.DecodeRequest(msg)
+	}
+	if msgType == websocket.BinaryMessage {
+		return c.clientManage.opts.BinaryEnvelope.Decode(msg)
 	}
+	return c.requestFormatFunc()(c, msg)
+}
 
-	res, err := handler(c, req.GetParams())
+// 处理请求，客户端正在关闭时回复并返回 ErrShuttingDown
+func (c *Client) HandleRequest(req IRequest) error {
+	if !c.beginRequest() {
+		c.SendError(req, ErrShuttingDown)
+		return ErrShuttingDown
+	}
+	defer c.inflight.Done()
+
+	ctx := NewRequestContext(c.ctx, c, req)
+
+	res, err := c.clientManage.GetClientHandler().Dispatch(ctx, c, req)
 	if err != nil {
+		c.SendError(req, err)
 		return err
 	}
+	if res == nil {
+		return nil
+	}
+
+	return c.SendReply(req, res)
+}
+
+// 开始处理请求，正在关闭时返回false
+func (c *Client) beginRequest() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.closing {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
 
+// 是否正在关闭
+func (c *Client) IsClosing() bool {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.closing
+}
+
+// 优雅关闭，不再处理新请求，等待处理中的请求完成、发送队列写完后发送关闭帧
+func (c *Client) Close(code int, reason string) {
+	c.setCloseStatus(code, reason)
+	c.closeOnce.Do(func() {
+		c.stateLock.Lock()
+		c.closing = true
+		c.stateLock.Unlock()
+
+		go func() {
+			c.rejectQueued()
+			c.inflight.Wait()
+			c.closeCh <- websocket.FormatCloseMessage(code, reason)
+		}()
+	})
+}
+
+// 发送回复，响应带回请求ID和路由
+//
+// 二进制请求的 ClientResponse 回复会转为二进制响应
+func (c *Client) SendReply(req IRequest, res IResponse) error {
+	if req != nil {
+		if r, ok := res.(IReplyResponse); ok {
+			id := ""
+			if ri, ok := req.(IRequestId); ok {
+				id = ri.GetId()
+			}
+			r.SetReply(id, req.GetUrl())
+		}
+		if cr, ok := res.(*ClientResponse); ok && messageTypeOf(req) == websocket.BinaryMessage {
+			br, err := binaryResponseFrom(cr)
+			if err != nil {
+				Log.Error(c.ctx, "EncodeResponse Error ", err)
+				return err
+			}
+			res = br
+		}
+	}
 	return c.SendResponse(res)
 }
 
+// 发送错误响应，req 为空表示请求无法解析
+//
+// 未知错误不返回给客户端，原始错误在这里记录日志
+func (c *Client) SendError(req IRequest, err error) error {
+	if _, ok := asError(err); !ok {
+		Log.Error(c.ctx, "Internal Error ", err)
+	}
+	fn := c.clientManage.opts.ErrorResponseFn
+	if fn == nil {
+		fn = DefaultErrorResponseFunc
+	}
+	res := fn(c, req, err)
+	if res == nil {
+		return nil
+	}
+	return c.SendReply(req, res)
+}
+
 // 写循环
 func (c *Client) WriteLoop() {
 	defer func() {
 		if err := recover(); err != nil {
-			Log.Error(context.Background(), "WriteLoop Panic ", err)
+			Log.Error(c.ctx, "WriteLoop Panic ", err)
 		}
 	}()
 
+	opts := c.clientManage.opts
+
 	//定时器，定时发送心跳包
-	ticker := time.NewTicker(HeartbeatInterval)
+	ticker := time.NewTicker(opts.HeartbeatInterval)
+
+	//优雅关闭时由读循环等待对方的关闭帧后断开
+	closeConn := true
 
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		if closeConn {
+			c.conn.Close()
+		}
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(WriteDeadline))
+		case closeMsg := <-c.closeCh:
+			//写完发送队列中剩余的消息
+			for drained := false; !drained; {
+				select {
+				case message := <-c.send:
+					c.conn.SetWriteDeadline(time.Now().Add(opts.WriteDeadline))
+					c.writeMessage(message)
+				default:
+					drained = true
+				}
+			}
 
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteDeadline))
+			if err := c.conn.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
 				return
 			}
+			//对方在超时内没有回复关闭帧时读循环退出
+			c.conn.SetReadDeadline(time.Now().Add(opts.CloseTimeout))
+			closeConn = false
+			return
 
-			if c.clientManage.resFormatFn == nil {
-				c.clientManage.resFormatFn = c.clientManage.DefaultResponseFormatFunc()
-			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteDeadline))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 
-			c.conn.WriteMessage(websocket.TextMessage, message)
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteDeadline))
+			if err := c.writeMessage(message); err != nil {
+				return
+			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(WriteDeadline))
+			c.conn.SetWriteDeadline(time.Now().Add(opts.WriteDeadline))
 
-			if err := c.conn.WriteMessage(websocket.PingMessage, []byte(PingMessage)); err != nil {
+			if err := c.conn.WriteMessage(websocket.PingMessage, []byte(opts.PingMessage)); err != nil {
 				return
 			}
 		}
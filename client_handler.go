@@ -1,27 +1,100 @@
 package go_websocket
 
-import "sync"
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
 
+// 全局默认路由表，管理器未指定路由表时使用
 var WsClientHandler = NewClientHandler()
 
-type HandlerFunc func(client *Client, params interface{}) (IResponse, error)
+// 处理方法，ctx 在客户端断开时取消
+type HandlerFunc func(ctx context.Context, client *Client, params interface{}) (IResponse, error)
+
+// 中间件
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// 路由
+type Route struct {
+	key         string
+	handler     HandlerFunc
+	middlewares []Middleware   //路由及路由组中间件
+	chain       HandlerFunc    //组合后的处理方法
+	raw         bool           //是否接收原始参数
+	timeout     time.Duration  //超时时间
+	ordered     bool           //是否按顺序处理
+	owner       *ClientHandler //所属路由表
+}
+
+// 路由key
+func (r *Route) GetKey() string {
+	return r.key
+}
+
+// 按顺序处理，启用并发处理时该路由的请求仍按到达顺序逐个处理
+func (r *Route) Ordered() *Route {
+	r.owner.lock.Lock()
+	defer r.owner.lock.Unlock()
+	r.ordered = true
+	return r
+}
+
+// 设置超时，请求上下文会带上截止时间
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.owner.lock.Lock()
+	defer r.owner.lock.Unlock()
+	r.timeout = d
+	return r
+}
 
 type ClientHandler struct {
-	handlers map[string]HandlerFunc
-	lock     sync.RWMutex
+	handlers    map[string]*Route
+	middlewares []Middleware //全局中间件
+	lock        sync.RWMutex
 }
 
 func NewClientHandler() *ClientHandler {
 	return &ClientHandler{
-		handlers: make(map[string]HandlerFunc),
+		handlers: make(map[string]*Route),
 		lock:     sync.RWMutex{},
 	}
 }
 
-func (h *ClientHandler) Register(key string, fn HandlerFunc) {
+// 添加全局中间件，对已注册的路由同样生效
+func (h *ClientHandler) Use(middlewares ...Middleware) {
 	h.lock.Lock()
 	defer h.lock.Unlock()
-	h.handlers[key] = fn
+	h.middlewares = append(h.middlewares, middlewares...)
+	for _, r := range h.handlers {
+		r.chain = h.buildChain(r)
+	}
+}
+
+// 注册路由，middlewares 只对该路由生效
+func (h *ClientHandler) Register(key string, fn HandlerFunc, middlewares ...Middleware) *Route {
+	return h.register(key, fn, false, middlewares)
+}
+
+// 注册接收原始参数的路由，请求支持时 params 为 json.RawMessage
+func (h *ClientHandler) registerRaw(key string, fn HandlerFunc, middlewares ...Middleware) *Route {
+	return h.register(key, fn, true, middlewares)
+}
+
+func (h *ClientHandler) register(key string, fn HandlerFunc, raw bool, middlewares []Middleware) *Route {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	r := &Route{
+		key:         key,
+		handler:     fn,
+		middlewares: middlewares,
+		raw:         raw,
+		owner:       h,
+	}
+	r.chain = h.buildChain(r)
+	h.handlers[key] = r
+	return r
 }
 
 func (h *ClientHandler) UnRegister(key string) {
@@ -30,9 +103,118 @@ func (h *ClientHandler) UnRegister(key string) {
 	delete(h.handlers, key)
 }
 
+// 获取组合了中间件的处理方法
 func (h *ClientHandler) GetHandler(key string) (HandlerFunc, bool) {
 	h.lock.RLock()
 	defer h.lock.RUnlock()
-	fn, ok := h.handlers[key]
-	return fn, ok
+	r, ok := h.handlers[key]
+	if !ok {
+		return nil, false
+	}
+	return r.chain, true
+}
+
+// 分发请求，带类型的路由优先使用原始参数
+func (h *ClientHandler) Dispatch(ctx context.Context, client *Client, req IRequest) (IResponse, error) {
+	h.lock.RLock()
+	r, ok := h.handlers[req.GetUrl()]
+	var chain HandlerFunc
+	var raw bool
+	var timeout time.Duration
+	if ok {
+		chain = r.chain
+		raw = r.raw
+		timeout = r.timeout
+	}
+	h.lock.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound.WithMsg(req.GetUrl() + " handler not found")
+	}
+
+	params := req.GetParams()
+	if raw {
+		if rr, ok := req.(IRawRequest); ok {
+			params = json.RawMessage(rr.GetRawParams())
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return chain(ctx, client, params)
+}
+
+// 路由是否按顺序处理
+func (h *ClientHandler) IsOrdered(key string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	r, ok := h.handlers[key]
+	return ok && r.ordered
+}
+
+// 获取路由
+func (h *ClientHandler) GetRoute(key string) (*Route, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	r, ok := h.handlers[key]
+	return r, ok
+}
+
+// 路由组
+func (h *ClientHandler) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		handler:     h,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// 组合中间件，执行顺序：全局、路由组、路由
+func (h *ClientHandler) buildChain(r *Route) HandlerFunc {
+	fn := r.handler
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		fn = r.middlewares[i](fn)
+	}
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		fn = h.middlewares[i](fn)
+	}
+	return fn
+}
+
+// 路由组
+type RouteGroup struct {
+	handler     *ClientHandler
+	prefix      string
+	middlewares []Middleware
+}
+
+// 添加路由组中间件，只对之后注册的路由生效
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// 子路由组
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		handler:     g.handler,
+		prefix:      g.prefix + prefix,
+		middlewares: g.combine(middlewares),
+	}
+}
+
+// 注册路由，key 会加上路由组前缀
+func (g *RouteGroup) Register(key string, fn HandlerFunc, middlewares ...Middleware) *Route {
+	return g.handler.Register(g.prefix+key, fn, g.combine(middlewares)...)
+}
+
+func (g *RouteGroup) registerRaw(key string, fn HandlerFunc, middlewares ...Middleware) *Route {
+	return g.handler.registerRaw(g.prefix+key, fn, g.combine(middlewares)...)
+}
+
+func (g *RouteGroup) combine(middlewares []Middleware) []Middleware {
+	list := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	list = append(list, g.middlewares...)
+	return append(list, middlewares...)
 }
@@ -1,136 +1,322 @@
 package go_websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"sync"
 )
 
+var (
+	ErrManageClosed  = errors.New("client manage closed")
+	ErrClientClosed  = errors.New("client closed")
+	ErrManageStarted = errors.New("client manage already started")
+)
+
 type ResponseFormatFunc func(c *Client, data []byte) (res IResponse, err error)
 type RequestFormatFunc func(c *Client, data []byte) (req IRequest, err error)
 
 type ClientManage struct {
-	clients     map[string]*Client //所有客户端
-	clientsLock sync.RWMutex       //客户端锁
-
-	register   chan *Client //注册通道
-	unregister chan *Client //退出通道
+	clients *registry //所有客户端
 
 	broadcast chan []byte //广播通道
 
-	groups      map[string]map[string]*Client //所有组客户端
-	groupsLock  sync.RWMutex                  //组锁
-	systems     map[string]map[string]*Client //所有系统客户端
-	systemsLock sync.RWMutex                  //系统锁
+	groups    map[string]map[string]*Client //所有组客户端
+	systems   map[string]map[string]*Client //所有系统客户端
+	users     map[string]map[string]*Client //所有用户客户端
+	indexLock sync.RWMutex                  //组、系统索引锁
 
 	reqFormatFn RequestFormatFunc  //请求格式化方法
 	resFormatFn ResponseFormatFunc //响应格式化方法
+	defaultRes  bool               //是否为默认的响应格式化方法，群发时只有默认方法共用编码结果
+
+	opts     Options   //配置
+	upgrader *Upgrader //升级器
+
+	closed        bool                 //是否已关闭
+	started       bool                 //是否已通过 Run 或 Start 启动
+	live          map[*Client]struct{} //读写循环未退出的客户端
+	lifecycleLock sync.Mutex           //生命周期锁
+	loops         sync.WaitGroup       //读写循环
+	handlers      sync.WaitGroup       //工作协程
+	done          chan struct{}        //关闭后事件循环退出
+
+	sessions     map[string]*session //可恢复的会话
+	sessionsLock sync.Mutex          //会话锁
+
+	acks     map[string]map[string]*pendingAck //等待确认的消息，按客户端ID、消息ID
+	acksLock sync.Mutex                        //等待确认锁
+
+	slowConsumer slowConsumerCounter //慢消费者统计
+	hookDropped  uint64              //队列满时丢弃的回调数
+	compression  compressionCounter  //压缩统计
 }
 
-func NewClientManage() *ClientManage {
-	return &ClientManage{
-		clients:     make(map[string]*Client),
-		clientsLock: sync.RWMutex{},
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		broadcast:   make(chan []byte),
-		groups:      make(map[string]map[string]*Client),
-		groupsLock:  sync.RWMutex{},
-		systems:     make(map[string]map[string]*Client),
-		systemsLock: sync.RWMutex{},
+func NewClientManage(opts ...Option) *ClientManage {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	upgrader := options.Upgrader
+	if upgrader == nil {
+		upgrader = NewUpgrader(options.UpgraderConfig)
+	}
+
+	if options.ClientHandler == nil {
+		options.ClientHandler = WsClientHandler
+	}
+
+	if options.IDGenerator == nil {
+		options.IDGenerator = DefaultIDGenerator()
+	}
+
+	if options.NodeId == "" {
+		options.NodeId = DefaultNodeId()
+	}
+
+	cm := &ClientManage{
+		clients:   newRegistry(),
+		broadcast: make(chan []byte),
+		groups:    make(map[string]map[string]*Client),
+		systems:   make(map[string]map[string]*Client),
+		users:     make(map[string]map[string]*Client),
+		opts:      options,
+		upgrader:  upgrader,
+		live:      make(map[*Client]struct{}),
+		done:      make(chan struct{}),
+		sessions:  make(map[string]*session),
+		acks:      make(map[string]map[string]*pendingAck),
 	}
+	cm.reqFormatFn = cm.DefaultRequestFormatFunc()
+	cm.resFormatFn = cm.DefaultResponseFormatFunc()
+	cm.defaultRes = true
+	return cm
+}
+
+// 配置
+func (cm *ClientManage) GetOptions() Options {
+	return cm.opts
+}
+
+// 升级器
+func (cm *ClientManage) GetUpgrader() *Upgrader {
+	return cm.upgrader
+}
+
+// 路由表
+func (cm *ClientManage) GetClientHandler() *ClientHandler {
+	return cm.opts.ClientHandler
 }
 
 // 注册
 func (cm *ClientManage) Register(c *Client) {
-	cm.register <- c
+	cm.AddClient(c)
 }
 
 // 退出
 func (cm *ClientManage) UnRegister(c *Client) {
-	cm.unregister <- c
+	cm.RemoveClient(c)
 }
 
-// 获取客户端
-func (cm *ClientManage) GetClientByID(id string) *Client {
-	cm.clientsLock.RLock()
-	defer cm.clientsLock.RUnlock()
+// 是否已关闭
+func (cm *ClientManage) IsClosed() bool {
+	cm.lifecycleLock.Lock()
+	defer cm.lifecycleLock.Unlock()
+	return cm.closed
+}
 
-	if c, ok := cm.clients[id]; ok {
-		return c
+// 启动客户端读写循环，管理器关闭后返回 ErrManageClosed
+func (cm *ClientManage) startClient(c *Client) error {
+	cm.lifecycleLock.Lock()
+	defer cm.lifecycleLock.Unlock()
+	if cm.closed {
+		return ErrManageClosed
 	}
+
+	cm.live[c] = struct{}{}
+	cm.loops.Add(2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer cm.loops.Done()
+		defer wg.Done()
+		c.ReadLoop()
+	}()
+	go func() {
+		defer cm.loops.Done()
+		defer wg.Done()
+		c.WriteLoop()
+	}()
+	go func() {
+		wg.Wait()
+		cm.lifecycleLock.Lock()
+		delete(cm.live, c)
+		cm.lifecycleLock.Unlock()
+	}()
 	return nil
 }
 
+// 优雅关闭
+//
+// 不再接受新连接，给每个客户端发送关闭帧（默认1001 going away），
+// 关闭前等待处理中的请求完成、发送队列写完。
+// ctx 超时后强制断开连接，等待读写循环退出后返回 ctx.Err()
+func (cm *ClientManage) Shutdown(ctx context.Context) error {
+	cm.lifecycleLock.Lock()
+	if cm.closed {
+		cm.lifecycleLock.Unlock()
+		return ErrManageClosed
+	}
+	cm.closed = true
+	clients := make([]*Client, 0, len(cm.live))
+	for c := range cm.live {
+		clients = append(clients, c)
+	}
+	cm.lifecycleLock.Unlock()
+
+	//等待恢复的客户端直接删除
+	cm.closeSessions()
+
+	for _, c := range clients {
+		c.Close(cm.opts.CloseCode, cm.opts.CloseReason)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cm.loops.Wait()
+		cm.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		cm.removePresenceNode()
+		close(cm.done)
+		return nil
+	case <-ctx.Done():
+	}
+
+	//超时强制断开
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	cm.loops.Wait()
+	cm.removePresenceNode()
+	close(cm.done)
+	return ctx.Err()
+}
+
+// 获取客户端
+func (cm *ClientManage) GetClientByID(id string) *Client {
+	return cm.clients.get(id)
+}
+
+// 客户端数量
+func (cm *ClientManage) GetClientCount() int {
+	return cm.clients.count()
+}
+
 // 客户端列表
 func (cm *ClientManage) GetClientList() []string {
-	if len(cm.clients) <= 0 {
+	clients := cm.clients.snapshot()
+	if len(clients) <= 0 {
 		return nil
 	}
 
-	cm.clientsLock.RLock()
-	defer cm.clientsLock.RUnlock()
-
-	list := make([]string, 0)
-	for k, _ := range cm.clients {
-		list = append(list, k)
+	list := make([]string, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c.GetID())
 	}
 	return list
 }
 
 // 获取系统列表
 func (cm *ClientManage) GetSystemList() map[string][]string {
-	if len(cm.systems) <= 0 {
+	cm.indexLock.RLock()
+	defer cm.indexLock.RUnlock()
+	return indexList(cm.systems)
+}
+
+// 获取组列表
+func (cm *ClientManage) GetGroupsList() map[string][]string {
+	cm.indexLock.RLock()
+	defer cm.indexLock.RUnlock()
+	return indexList(cm.groups)
+}
+
+func indexList(index map[string]map[string]*Client) map[string][]string {
+	if len(index) <= 0 {
 		return nil
 	}
 
-	cm.systemsLock.RLock()
-	defer cm.systemsLock.RUnlock()
-
-	list := make(map[string][]string)
-	for k, system := range cm.systems {
-		list[k] = make([]string, 0)
-		for id, _ := range system {
+	list := make(map[string][]string, len(index))
+	for k, clients := range index {
+		list[k] = make([]string, 0, len(clients))
+		for id := range clients {
 			list[k] = append(list[k], id)
 		}
 	}
 	return list
 }
 
-// 获取组列表
-func (cm *ClientManage) GetGroupsList() map[string][]string {
-	if len(cm.groups) <= 0 {
-		return nil
+// 事件循环，阻塞直到关闭。配置了消息总线时订阅其他节点转发的消息，订阅失败时在后台重试直到成功；
+// 配置了在线状态存储时定时发送节点心跳
+//
+// 需要在订阅失败时报错的可以使用 Start，两者只能调用一个
+func (cm *ClientManage) Run() {
+	if !cm.setStarted(true) {
+		Log.Error(context.Background(), "Run Error ", ErrManageStarted)
+		return
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if cm.opts.Broker != nil {
+		go cm.subscribeRetry(ctx)
+	}
+	cm.run(ctx, cancel)
+}
 
-	cm.groupsLock.RLock()
-	defer cm.groupsLock.RUnlock()
-
-	list := make(map[string][]string)
-	for k, group := range cm.groups {
-		list[k] = make([]string, 0)
-		for id, _ := range group {
-			list[k] = append(list[k], id)
+// 启动，配置了消息总线时先订阅其他节点转发的消息，订阅失败时返回错误，成功后事件循环在后台运行
+func (cm *ClientManage) Start() error {
+	if !cm.setStarted(true) {
+		return ErrManageStarted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if cm.opts.Broker != nil {
+		if err := cm.subscribe(ctx); err != nil {
+			cancel()
+			cm.setStarted(false)
+			return err
 		}
 	}
-	return list
+	go cm.run(ctx, cancel)
+	return nil
 }
 
-// 事件循环
-func (cm *ClientManage) Run() {
+// 设置启动状态，已是该状态时返回false
+func (cm *ClientManage) setStarted(started bool) bool {
+	cm.lifecycleLock.Lock()
+	defer cm.lifecycleLock.Unlock()
+	if cm.started == started {
+		return false
+	}
+	cm.started = started
+	return true
+}
+
+// 事件循环，关闭后取消 ctx
+func (cm *ClientManage) run(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+
+	if cm.opts.Presence != nil {
+		go cm.presenceHeartbeat(ctx)
+	}
+
 	for {
 		select {
-		case client, ok := <-cm.register:
-			if !ok {
-				//通道关闭直接return
-				return
-			}
-			cm.AddClient(client)
-		case client, ok := <-cm.unregister:
-			if !ok {
-				//通道关闭直接return
-				return
-			}
-			cm.RemoveClient(client)
+		case <-cm.done:
+			return
 		case msg, ok := <-cm.broadcast:
 			if !ok {
 				//通道关闭直接return
@@ -142,72 +328,65 @@ func (cm *ClientManage) Run() {
 }
 
 // 全局广播
-func (cm *ClientManage) Broadcast(msg []byte) {
-	if len(cm.clients) <= 0 {
-		return
-	}
-	for _, c := range cm.clients {
-		c.SendMsg(msg)
-	}
+func (cm *ClientManage) Broadcast(msg []byte) *DeliveryReport {
+	return cm.Send(msg, Target{Broadcast: true})
 }
 
-// 给组发消息
-func (cm *ClientManage) SendGroupMsg(msg []byte, groups ...string) {
-	if len(groups) <= 0 {
-		return
-	}
-	for _, g := range groups {
-		if _, ok := cm.groups[g]; ok {
-			for _, c := range cm.groups[g] {
-				c.SendMsg(msg)
-			}
-		}
-	}
+// 全局广播二进制消息
+func (cm *ClientManage) BroadcastBinary(data []byte) *DeliveryReport {
+	return cm.SendBinary(data, Target{Broadcast: true})
+}
+
+// 给组发消息，同时在多个组的客户端只发送一次
+func (cm *ClientManage) SendGroupMsg(msg []byte, groups ...string) *DeliveryReport {
+	return cm.Send(msg, Target{Groups: groups})
 }
 
 // 给系统发消息
-func (cm *ClientManage) SendSystemMsg(msg []byte, systemIds ...string) {
-	if len(systemIds) <= 0 {
-		return
-	}
-	for _, s := range systemIds {
-		if _, ok := cm.systems[s]; ok {
-			for _, c := range cm.systems[s] {
-				c.SendMsg(msg)
-			}
-		}
-	}
+func (cm *ClientManage) SendSystemMsg(msg []byte, systemIds ...string) *DeliveryReport {
+	return cm.Send(msg, Target{Systems: systemIds})
 }
 
 // 给多个客户端发消息
-func (cm *ClientManage) SendClientMsg(msg []byte, clientIds ...string) {
-	if len(clientIds) <= 0 {
-		return
-	}
-	for _, id := range clientIds {
-		c := cm.GetClientByID(id)
-		if c != nil {
-			c.SendMsg(msg)
-		}
+func (cm *ClientManage) SendClientMsg(msg []byte, clientIds ...string) *DeliveryReport {
+	return cm.Send(msg, Target{Clients: clientIds})
+}
+
+// 组或系统成员快照，发送时不持有锁
+func (cm *ClientManage) indexMembers(index map[string]map[string]*Client, key string) []*Client {
+	cm.indexLock.RLock()
+	defer cm.indexLock.RUnlock()
+	members := index[key]
+	list := make([]*Client, 0, len(members))
+	for _, c := range members {
+		list = append(list, c)
 	}
+	return list
 }
 
 // 添加客户端
 func (cm *ClientManage) AddClient(c *Client) {
-	cm.clientsLock.Lock()
-	defer cm.clientsLock.Unlock()
-
-	if _, ok := cm.clients[c.GetID()]; ok {
+	if !cm.clients.add(c) {
 		return
 	}
 
-	cm.clients[c.GetID()] = c
+	cm.indexLock.Lock()
+	c.registered = true
 
 	//添加进系统
-	cm.AddSystemIdByClient(c, c.GetSystemId())
+	cm.addIndex(cm.systems, c.GetSystemId(), c)
 
 	//添加进组
-	cm.AddGroupsByClient(c, c.GetGroups()...)
+	for _, g := range c.GetGroups() {
+		cm.addIndex(cm.groups, g, c)
+	}
+
+	//添加进用户
+	cm.addIndex(cm.users, c.GetUserId(), c)
+	cm.indexLock.Unlock()
+
+	cm.syncPresence(c)
+	cm.onConnect(c)
 }
 
 // 给客户端添加系统
@@ -216,49 +395,69 @@ func (cm *ClientManage) AddSystemIdByClient(c *Client, systemId string) {
 		return
 	}
 
-	cm.systemsLock.Lock()
-	defer cm.systemsLock.Unlock()
+	cm.indexLock.Lock()
+	defer cm.indexLock.Unlock()
 
-	if _, ok := cm.systems[systemId]; !ok {
-		cm.systems[systemId] = make(map[string]*Client)
+	if c.registered {
+		cm.addIndex(cm.systems, systemId, c)
 	}
-
-	cm.systems[systemId][c.GetID()] = c
 }
 
-// 给客户端添加组
+// 给客户端添加组，客户端未注册时只记录在客户端上，注册时再加入索引
 func (cm *ClientManage) AddGroupsByClient(c *Client, groups ...string) {
 	if len(groups) <= 0 {
 		return
 	}
 
-	cm.groupsLock.Lock()
-	defer cm.groupsLock.Unlock()
-
+	cm.indexLock.Lock()
+	joined := make([]string, 0, len(groups))
 	for _, g := range groups {
-		if _, ok := cm.groups[g]; !ok {
-			cm.groups[g] = make(map[string]*Client)
+		if !c.InGroup(g) {
+			joined = append(joined, g)
+		}
+	}
+	c.AddGroup(groups...)
+	registered := c.registered
+	if registered {
+		for _, g := range groups {
+			cm.addIndex(cm.groups, g, c)
 		}
-		cm.groups[g][c.GetID()] = c
+	}
+	cm.indexLock.Unlock()
 
-		c.AddGroup(g)
+	if registered {
+		cm.syncPresence(c)
+		cm.onGroupJoin(c, joined)
 	}
 }
 
-// 删除客户端
+// 删除客户端，之后对该客户端的发送返回 ErrClientClosed
 func (cm *ClientManage) RemoveClient(c *Client) {
-	cm.clientsLock.Lock()
-	defer cm.clientsLock.Unlock()
+	defer cm.syncPresence(c)
+	defer c.markClosed()
 
-	delete(cm.clients, c.GetID())
+	if !cm.clients.remove(c) {
+		return
+	}
+	cm.endSession(c)
+	cm.closePendingAcks(c.GetID())
+	defer cm.onDisconnect(c)
 
-	close(c.send)
+	cm.indexLock.Lock()
+	defer cm.indexLock.Unlock()
+
+	c.registered = false
 
 	//删除系统
-	cm.RemoveSystemIdByClient(c, c.GetSystemId())
+	cm.removeIndex(cm.systems, c.GetSystemId(), c)
+
+	//删除组，保留客户端上的组信息
+	for _, g := range c.GetGroups() {
+		cm.removeIndex(cm.groups, g, c)
+	}
 
-	//删除组
-	cm.RemoveGroupsByClient(c, c.GetGroups()...)
+	//删除用户
+	cm.removeIndex(cm.users, c.GetUserId(), c)
 }
 
 // 给客户端删除系统
@@ -267,14 +466,10 @@ func (cm *ClientManage) RemoveSystemIdByClient(c *Client, systemId string) {
 		return
 	}
 
-	cm.systemsLock.Lock()
-	defer cm.systemsLock.Unlock()
-
-	if _, ok := cm.systems[systemId]; !ok {
-		return
-	}
+	cm.indexLock.Lock()
+	defer cm.indexLock.Unlock()
 
-	delete(cm.systems[systemId], c.GetID())
+	cm.removeIndex(cm.systems, systemId, c)
 }
 
 // 给客户端删除组
@@ -283,21 +478,57 @@ func (cm *ClientManage) RemoveGroupsByClient(c *Client, groups ...string) {
 		return
 	}
 
-	cm.groupsLock.Lock()
-	defer cm.groupsLock.Unlock()
-
+	cm.indexLock.Lock()
+	left := make([]string, 0, len(groups))
 	for _, g := range groups {
-		if _, ok := cm.groups[g]; !ok {
-			continue
+		if c.InGroup(g) {
+			left = append(left, g)
 		}
-		delete(cm.groups[g], c.GetID())
+	}
+	c.DelGroup(groups...)
+	for _, g := range groups {
+		cm.removeIndex(cm.groups, g, c)
+	}
+	registered := c.registered
+	cm.indexLock.Unlock()
 
-		c.DelGroup(g)
+	if registered {
+		cm.syncPresence(c)
+		cm.onGroupLeave(c, left)
+	}
+}
+
+// 加入索引，需持有 indexLock
+func (cm *ClientManage) addIndex(index map[string]map[string]*Client, key string, c *Client) {
+	if len(key) <= 0 {
+		return
+	}
+	if _, ok := index[key]; !ok {
+		index[key] = make(map[string]*Client)
 	}
+	index[key][c.GetID()] = c
 }
 
-// 设置响应格式化方法
+// 移出索引，空的组或系统一并删除，需持有 indexLock
+func (cm *ClientManage) removeIndex(index map[string]map[string]*Client, key string, c *Client) {
+	members, ok := index[key]
+	if !ok {
+		return
+	}
+	if old, ok := members[c.GetID()]; ok && old == c {
+		delete(members, c.GetID())
+	}
+	if len(members) <= 0 {
+		delete(index, key)
+	}
+}
+
+// 设置响应格式化方法，设置后群发时按客户端分别格式化
 func (cm *ClientManage) SetResponseFormatFunc(fn ResponseFormatFunc) {
+	cm.defaultRes = fn == nil
+	if fn == nil {
+		fn = cm.DefaultResponseFormatFunc()
+	}
 	cm.resFormatFn = fn
 }
 
@@ -314,6 +545,9 @@ func (cm *ClientManage) DefaultResponseFormatFunc() ResponseFormatFunc {
 
 // 设置请求格式化方法
 func (cm *ClientManage) SetRequestFormatFunc(fn RequestFormatFunc) {
+	if fn == nil {
+		fn = cm.DefaultRequestFormatFunc()
+	}
 	cm.reqFormatFn = fn
 }
 
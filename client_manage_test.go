@@ -0,0 +1,222 @@
+package go_websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 启动管理器和测试服务，返回 ws 地址
+func newTestServer(t *testing.T, cm *ClientManage) string {
+	t.Helper()
+	go cm.Run()
+	return serveTest(t, cm)
+}
+
+// 启动测试服务，返回 ws 地址，管理器需已启动
+func serveTest(t *testing.T, cm *ClientManage) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(cm, w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// 连接并等待注册完成
+func dialClient(t *testing.T, cm *ClientManage, u string) (*websocket.Conn, *Client) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var c *Client
+	waitFor(t, 3*time.Second, func() bool {
+		for _, id := range cm.GetClientList() {
+			c = cm.GetClientByID(id)
+		}
+		return c != nil
+	})
+	return conn, c
+}
+
+// 读取直到连接关闭
+func drain(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// 等待条件成立
+func waitFor(t *testing.T, timeout time.Duration, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistryChurn(t *testing.T) {
+	cm := NewClientManage()
+	go cm.Run()
+	msg, _ := NewOkClientRes("x").GetBytes()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				c := NewClient(fmt.Sprintf("%d-%d", w, i), fmt.Sprint("s", i%3), nil, cm)
+				go func() {
+					for {
+						select {
+						case <-c.send:
+						case <-c.done:
+							return
+						}
+					}
+				}()
+				cm.AddGroupsByClient(c, "g1")
+				cm.Register(c)
+				cm.AddGroupsByClient(c, fmt.Sprint("g", i%5))
+				c.SendMsg(msg)
+				cm.RemoveGroupsByClient(c, "g1")
+				cm.UnRegister(c)
+				if err := c.SendMsg(msg); err != ErrClientClosed {
+					t.Errorf("SendMsg after UnRegister: %v", err)
+				}
+				cm.AddGroupsByClient(c, "late")
+			}
+		}(w)
+	}
+
+	stop := make(chan struct{})
+	var bg sync.WaitGroup
+	for b := 0; b < 4; b++ {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cm.Broadcast(msg)
+				cm.SendGroupMsg(msg, "g1", "g2")
+				cm.SendSystemMsg(msg, "s1")
+				cm.SendClientMsg(msg, "1-1", "2-2")
+				cm.GetGroupsList()
+				cm.GetSystemList()
+				cm.GetClientList()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	bg.Wait()
+
+	if n := cm.GetClientCount(); n != 0 {
+		t.Fatalf("clients left: %d", n)
+	}
+	if g := cm.GetGroupsList(); len(g) != 0 {
+		t.Fatalf("groups left: %v", g)
+	}
+	if s := cm.GetSystemList(); len(s) != 0 {
+		t.Fatalf("systems left: %v", s)
+	}
+}
+
+func TestConnectionChurnAndShutdown(t *testing.T) {
+	cm := NewClientManage()
+	u := newTestServer(t, cm)
+	msg, _ := NewOkClientRes("x").GetBytes()
+
+	const total = 200
+	stop := make(chan struct{})
+	var bg sync.WaitGroup
+	for b := 0; b < 4; b++ {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cm.Broadcast(msg)
+				cm.SendGroupMsg(msg, "g0", "g1")
+			}
+		}()
+	}
+
+	//一半连接后立即断开，一半保持到关闭
+	var wg sync.WaitGroup
+	kept := make(chan *websocket.Conn, total)
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?group=g%d", u, i%3), nil)
+			if err != nil {
+				t.Errorf("Dial: %v", err)
+				return
+			}
+			if i%2 == 0 {
+				conn.Close()
+				return
+			}
+			kept <- conn
+		}(i)
+	}
+	wg.Wait()
+	close(kept)
+
+	var readers sync.WaitGroup
+	for conn := range kept {
+		readers.Add(1)
+		go func(conn *websocket.Conn) {
+			defer readers.Done()
+			defer conn.Close()
+			drain(conn)
+		}(conn)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		return cm.GetClientCount() == total/2
+	})
+	close(stop)
+	bg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cm.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	readers.Wait()
+
+	if n := cm.GetClientCount(); n != 0 {
+		t.Fatalf("clients left: %d", n)
+	}
+	if g := cm.GetGroupsList(); len(g) != 0 {
+		t.Fatalf("groups left: %v", g)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(u, nil); err == nil {
+		t.Fatal("Dial after Shutdown succeeded")
+	}
+}
@@ -11,10 +11,66 @@ type IRequest interface {
 	GetParams() interface{}
 }
 
+// 带请求ID的请求，请求ID会原样带回响应
+type IRequestId interface {
+	GetId() string
+}
+
+// 可获取原始参数的请求
+type IRawRequest interface {
+	GetRawParams() []byte
+}
+
+// 可关联请求的响应
+type IReplyResponse interface {
+	SetReply(id string, url string)
+}
+
+// 可标记为推送的响应
+type IPushResponse interface {
+	SetPush()
+}
+
+// 可编号的响应，开启会话恢复时发送的响应带上序号
+type ISeqResponse interface {
+	SetSeq(seq uint64)
+}
+
+// 响应类型
+const (
+	ResponseTypeReply   = "reply"   //请求的回复
+	ResponseTypePush    = "push"    //服务端推送
+	ResponseTypeSession = "session" //会话信息
+)
+
 // 客户端请求
 type ClientRequest struct {
-	Url    string      `json:"url"`
-	Params interface{} `json:"params"`
+	Id        string          `json:"id,omitempty"`
+	Url       string          `json:"url"`
+	Params    interface{}     `json:"params"`
+	RawParams json.RawMessage `json:"-"` //原始参数
+}
+
+// 解析时保留原始参数
+func (r *ClientRequest) UnmarshalJSON(data []byte) error {
+	type alias ClientRequest
+	aux := struct {
+		*alias
+		Params json.RawMessage `json:"params"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.RawParams = aux.Params
+	r.Params = nil
+	if len(aux.Params) > 0 {
+		return json.Unmarshal(aux.Params, &r.Params)
+	}
+	return nil
+}
+
+func (r *ClientRequest) GetId() string {
+	return r.Id
 }
 
 func (r *ClientRequest) GetUrl() string {
@@ -25,11 +81,20 @@ func (r *ClientRequest) GetParams() interface{} {
 	return r.Params
 }
 
+func (r *ClientRequest) GetRawParams() []byte {
+	return r.RawParams
+}
+
 // 客户端响应
 type ClientResponse struct {
-	Code int         `json:"code"`
-	Msg  string      `json:"msg"`
-	Data interface{} `json:"data"`
+	Id    string      `json:"id,omitempty"`     //对应的请求ID
+	Url   string      `json:"url,omitempty"`    //对应的请求路由
+	Type  string      `json:"type,omitempty"`   //响应类型，reply、push 或 session
+	Seq   uint64      `json:"seq,omitempty"`    //序号，开启会话恢复时按客户端递增
+	MsgId string      `json:"msg_id,omitempty"` //消息ID，可靠发送时客户端按消息ID确认和去重
+	Code  int         `json:"code"`
+	Msg   string      `json:"msg"`
+	Data  interface{} `json:"data"`
 }
 
 func NewClientResponse(code int, msg string, data interface{}) *ClientResponse {
@@ -56,6 +121,29 @@ func NewErrClientRes(msg string, data interface{}) *ClientResponse {
 	}
 }
 
+// 关联请求
+func (r *ClientResponse) SetReply(id string, url string) {
+	r.Id = id
+	r.Url = url
+	r.Type = ResponseTypeReply
+}
+
+// 标记为推送
+func (r *ClientResponse) SetPush() {
+	r.Id = ""
+	r.Type = ResponseTypePush
+}
+
+// 设置消息ID
+func (r *ClientResponse) SetMsgId(id string) {
+	r.MsgId = id
+}
+
+// 设置序号
+func (r *ClientResponse) SetSeq(seq uint64) {
+	r.Seq = seq
+}
+
 func (r *ClientResponse) GetBytes() ([]byte, error) {
 	data, err := json.Marshal(r)
 	if err != nil {
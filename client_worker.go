@@ -0,0 +1,121 @@
+package go_websocket
+
+import "net/http"
+
+// 请求队列满时的处理策略
+type QueueFullPolicy int8
+
+const (
+	QueueFullReject QueueFullPolicy = iota //返回 ErrBusy 错误响应
+	QueueFullBlock                         //阻塞读循环直到队列有空位
+	QueueFullDrop                          //直接丢弃
+)
+
+func (p QueueFullPolicy) String() string {
+	switch p {
+	case QueueFullReject:
+		return "reject"
+	case QueueFullBlock:
+		return "block"
+	case QueueFullDrop:
+		return "drop"
+	}
+	return ""
+}
+
+var (
+	ErrBusy         = NewError(http.StatusServiceUnavailable, "busy", "too many pending requests")
+	ErrShuttingDown = NewError(http.StatusServiceUnavailable, "shutting_down", "server shutting down")
+)
+
+// 是否启用并发处理
+func (c *Client) concurrent() bool {
+	return c.clientManage.opts.Concurrency > 1
+}
+
+// 启动工作协程，并发处理无序路由，有序路由由单独的协程按顺序处理
+func (c *Client) startWorkers() {
+	n := c.clientManage.opts.Concurrency
+	c.clientManage.handlers.Add(n + 1)
+	for i := 0; i < n; i++ {
+		go c.worker(c.jobs)
+	}
+	go c.worker(c.orderedJobs)
+}
+
+func (c *Client) worker(jobs chan IRequest) {
+	defer func() {
+		if err := recover(); err != nil {
+			Log.Error(c.ctx, "Worker Panic ", err)
+			//重新拉起，保证并发数不变
+			c.clientManage.handlers.Add(1)
+			go c.worker(jobs)
+		}
+		c.clientManage.handlers.Done()
+	}()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case req := <-jobs:
+			if err := c.HandleRequest(req); err != nil && err != ErrShuttingDown {
+				Log.Error(c.ctx, "HandleRequest Error ", err)
+				c.clientManage.onError(c, err)
+			}
+		}
+	}
+}
+
+// 请求入队，队列满时按策略处理
+func (c *Client) enqueueRequest(req IRequest) error {
+	if c.IsClosing() {
+		c.SendError(req, ErrShuttingDown)
+		return ErrShuttingDown
+	}
+
+	jobs := c.jobs
+	if c.clientManage.GetClientHandler().IsOrdered(req.GetUrl()) {
+		jobs = c.orderedJobs
+	}
+
+	switch c.clientManage.opts.QueueFullPolicy {
+	case QueueFullBlock:
+		select {
+		case jobs <- req:
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	case QueueFullDrop:
+		select {
+		case jobs <- req:
+		default:
+			Log.Warn(c.ctx, "Request Dropped ", req.GetUrl())
+		}
+	default:
+		select {
+		case jobs <- req:
+		default:
+			c.SendError(req, ErrBusy)
+			return ErrBusy
+		}
+	}
+	return nil
+}
+
+// 关闭时拒绝还在队列中的请求
+func (c *Client) rejectQueued() {
+	if !c.concurrent() {
+		return
+	}
+	for _, jobs := range []chan IRequest{c.jobs, c.orderedJobs} {
+		for drained := false; !drained; {
+			select {
+			case req := <-jobs:
+				c.SendError(req, ErrShuttingDown)
+			default:
+				drained = true
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 读取一条回复
+func readReply(t *testing.T, conn *websocket.Conn) ClientResponse {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ClientResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// 错误响应的错误码
+func errorCode(res ClientResponse) string {
+	data, _ := json.Marshal(res.Data)
+	var e ErrorData
+	json.Unmarshal(data, &e)
+	return e.Code
+}
+
+func TestHandleRequestShuttingDown(t *testing.T) {
+	cm := NewClientManage()
+	c := NewClient("1", "s", nil, cm)
+	c.Close(websocket.CloseNormalClosure, "")
+
+	if err := c.HandleRequest(&ClientRequest{Id: "1", Url: "/x"}); err != ErrShuttingDown {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	var m *outMessage
+	select {
+	case m = <-c.send:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no response")
+	}
+	var res ClientResponse
+	json.Unmarshal(m.data, &res)
+	if res.Id != "1" || res.Code != 503 || errorCode(res) != "shutting_down" {
+		t.Fatalf("unexpected response %s", m.data)
+	}
+}
+
+func TestEnqueueRequestShuttingDown(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	h := NewClientHandler()
+	h.Register("/slow", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		started <- struct{}{}
+		<-release
+		return NewOkClientRes("slow"), nil
+	})
+	cm := NewClientManage(WithClientHandler(h), WithConcurrency(2, 4))
+	u := newTestServer(t, cm)
+	conn, c := dialClient(t, cm, u)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","url":"/slow"}`))
+	<-started
+	c.Close(websocket.CloseNormalClosure, "")
+
+	//关闭期间的请求立即回复，处理中的请求完成后再发送关闭帧
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"2","url":"/slow"}`))
+	if res := readReply(t, conn); res.Id != "2" || errorCode(res) != "shutting_down" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	close(release)
+	if res := readReply(t, conn); res.Id != "1" || res.Data != "slow" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected close frame, got %v", err)
+	}
+}
+
+// 处理器阻塞到 release 关闭的测试服务
+func newBlockingServer(t *testing.T, opts ...Option) (*websocket.Conn, chan struct{}, chan struct{}) {
+	t.Helper()
+	started, release := make(chan struct{}, 16), make(chan struct{})
+	h := NewClientHandler()
+	h.Register("/slow", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		started <- struct{}{}
+		<-release
+		return NewOkClientRes("slow"), nil
+	})
+	h.Register("/fast", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		return NewOkClientRes("fast"), nil
+	})
+	h.Register("/ping", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		return NewOkClientRes("pong"), nil
+	}).Ordered()
+	h.Register("/panic", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		panic("boom")
+	})
+	cm := NewClientManage(append([]Option{WithClientHandler(h)}, opts...)...)
+	u := newTestServer(t, cm)
+	conn, _ := dialClient(t, cm, u)
+	return conn, started, release
+}
+
+func sendReq(conn *websocket.Conn, id, url string) {
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"`+id+`","url":"`+url+`"}`))
+}
+
+// 两个工作协程都在处理，队列中还有一个请求
+func fillQueue(t *testing.T, conn *websocket.Conn, started chan struct{}) {
+	t.Helper()
+	sendReq(conn, "a", "/slow")
+	sendReq(conn, "b", "/slow")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			t.Fatal("handler not started")
+		}
+	}
+	sendReq(conn, "c", "/slow")
+}
+
+// 读取 n 条回复，返回回复的请求ID
+func readReplies(t *testing.T, conn *websocket.Conn, n int) map[string]ClientResponse {
+	t.Helper()
+	replies := make(map[string]ClientResponse)
+	for i := 0; i < n; i++ {
+		res := readReply(t, conn)
+		replies[res.Id] = res
+	}
+	return replies
+}
+
+func TestQueueFullReject(t *testing.T) {
+	conn, started, release := newBlockingServer(t, WithConcurrency(2, 1), WithQueueFullPolicy(QueueFullReject))
+	fillQueue(t, conn, started)
+
+	sendReq(conn, "d", "/fast")
+	if res := readReply(t, conn); res.Id != "d" || res.Code != 503 || errorCode(res) != "busy" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	close(release)
+	replies := readReplies(t, conn, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		if replies[id].Data != "slow" {
+			t.Fatalf("missing reply %s: %+v", id, replies)
+		}
+	}
+}
+
+func TestQueueFullDrop(t *testing.T) {
+	conn, started, release := newBlockingServer(t, WithConcurrency(2, 1), WithQueueFullPolicy(QueueFullDrop))
+	fillQueue(t, conn, started)
+
+	sendReq(conn, "d", "/fast")
+	//有序路由由单独的协程处理，收到回复说明 d 已被读取
+	sendReq(conn, "ping", "/ping")
+	if res := readReply(t, conn); res.Id != "ping" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	close(release)
+	sendReq(conn, "e", "/fast")
+	replies := readReplies(t, conn, 4)
+	if _, ok := replies["d"]; ok {
+		t.Fatalf("dropped request replied: %+v", replies)
+	}
+	for _, id := range []string{"a", "b", "c", "e"} {
+		if _, ok := replies[id]; !ok {
+			t.Fatalf("missing reply %s: %+v", id, replies)
+		}
+	}
+}
+
+func TestQueueFullBlock(t *testing.T) {
+	conn, started, release := newBlockingServer(t, WithConcurrency(2, 1), WithQueueFullPolicy(QueueFullBlock))
+	fillQueue(t, conn, started)
+
+	//读循环阻塞在入队上，队列有空位后继续处理
+	sendReq(conn, "d", "/fast")
+	sendReq(conn, "e", "/fast")
+	close(release)
+	replies := readReplies(t, conn, 5)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if res, ok := replies[id]; !ok || res.Code != 200 {
+			t.Fatalf("missing reply %s: %+v", id, replies)
+		}
+	}
+}
+
+func TestOrderedRoute(t *testing.T) {
+	var order []int
+	h := NewClientHandler()
+	h.Register("/seq", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		n := int(p.(float64))
+		//先到的请求处理得更慢
+		time.Sleep(time.Duration(10-n%10) * time.Millisecond)
+		order = append(order, n)
+		return NewOkClientRes(n), nil
+	}).Ordered()
+	cm := NewClientManage(WithClientHandler(h), WithConcurrency(4, 32))
+	u := newTestServer(t, cm)
+	conn, _ := dialClient(t, cm, u)
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"id":"%d","url":"/seq","params":%d}`, i, i)))
+	}
+	for i := 0; i < total; i++ {
+		if res := readReply(t, conn); res.Id != fmt.Sprint(i) {
+			t.Fatalf("reply %d: %+v", i, res)
+		}
+	}
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("handled out of order: %v", order)
+		}
+	}
+}
+
+func TestWorkerRespawn(t *testing.T) {
+	conn, started, release := newBlockingServer(t, WithConcurrency(2, 4))
+	defer close(release)
+
+	for i := 0; i < 4; i++ {
+		sendReq(conn, fmt.Sprint("p", i), "/panic")
+	}
+	//工作协程 panic 后重新拉起，仍能同时处理两个请求
+	sendReq(conn, "a", "/slow")
+	sendReq(conn, "b", "/slow")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			t.Fatal("workers not respawned")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package go_websocket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrCodecNotFound    = errors.New("codec not found")
+	ErrCodecUnsupported = errors.New("codec unsupported value")
+)
+
+// 编解码器，负责请求、响应信封及其负载的编解码
+//
+// 客户端选择编解码器后，所有消息都通过编解码器解析，不再使用请求格式化方法和二进制信封
+type Codec interface {
+	Name() string                                       //名称，对应查询参数 codec 的值
+	MessageType() int                                   //发送的消息类型
+	Marshal(v interface{}) ([]byte, error)              //编码负载
+	Unmarshal(data []byte, v interface{}) error         //解码负载
+	DecodeRequest(data []byte) (*CodecRequest, error)   //解码请求信封
+	EncodeResponse(res *ClientResponse) ([]byte, error) //编码响应信封
+}
+
+// 编解码器解析的请求，参数保留原始编码，带类型的处理方法使用同一编解码器解码
+type CodecRequest struct {
+	Id        string      //请求ID
+	Url       string      //路由
+	Params    interface{} //参数，无法通用解码时为原始编码
+	RawParams []byte      //原始参数
+}
+
+func (r *CodecRequest) GetId() string {
+	return r.Id
+}
+
+func (r *CodecRequest) GetUrl() string {
+	return r.Url
+}
+
+func (r *CodecRequest) GetParams() interface{} {
+	return r.Params
+}
+
+func (r *CodecRequest) GetRawParams() []byte {
+	return r.RawParams
+}
+
+// 内置编解码器
+func DefaultCodecs() []Codec {
+	return []Codec{
+		JSONCodec{},
+		MsgpackCodec{},
+		CBORCodec{},
+		ProtobufCodec{},
+	}
+}
+
+// 按名称查找编解码器
+func findCodec(codecs []Codec, name string) (Codec, bool) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// 使用编解码器解码参数，未选择编解码器时按JSON解码
+func (c *Client) DecodeParams(params interface{}, v interface{}) error {
+	if c.codec != nil {
+		switch p := params.(type) {
+		case json.RawMessage:
+			if len(p) == 0 {
+				return nil
+			}
+			return c.codec.Unmarshal(p, v)
+		case []byte:
+			if len(p) == 0 {
+				return nil
+			}
+			return c.codec.Unmarshal(p, v)
+		}
+	}
+	return DecodeParams(params, v)
+}
+
+// JSON编解码器，文本消息
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JSONCodec) DecodeRequest(data []byte) (*CodecRequest, error) {
+	req := &ClientRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	return &CodecRequest{
+		Id:        req.Id,
+		Url:       req.Url,
+		Params:    req.Params,
+		RawParams: req.RawParams,
+	}, nil
+}
+
+func (JSONCodec) EncodeResponse(res *ClientResponse) ([]byte, error) {
+	return json.Marshal(res)
+}
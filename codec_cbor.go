@@ -0,0 +1,68 @@
+package go_websocket
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+)
+
+// CBOR解码配置，通用解码时 map 使用字符串键
+var cborDecMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// CBOR编解码器，二进制消息，字段名沿用 json 标签
+type CBORCodec struct{}
+
+// 请求信封
+type cborRequest struct {
+	Id     string          `json:"id"`
+	Url    string          `json:"url"`
+	Params cbor.RawMessage `json:"params"`
+}
+
+func (CBORCodec) Name() string {
+	return "cbor"
+}
+
+func (CBORCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	return cborDecMode.Unmarshal(data, v)
+}
+
+func (c CBORCodec) DecodeRequest(data []byte) (*CodecRequest, error) {
+	req := &cborRequest{}
+	if err := c.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	var params interface{}
+	if len(req.Params) > 0 {
+		if err := c.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	return &CodecRequest{
+		Id:        req.Id,
+		Url:       req.Url,
+		Params:    params,
+		RawParams: req.Params,
+	}, nil
+}
+
+func (c CBORCodec) EncodeResponse(res *ClientResponse) ([]byte, error) {
+	return c.Marshal(res)
+}
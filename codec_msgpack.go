@@ -0,0 +1,67 @@
+package go_websocket
+
+import (
+	"bytes"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack编解码器，二进制消息，字段名沿用 json 标签
+type MsgpackCodec struct{}
+
+// 请求信封
+type msgpackRequest struct {
+	Id     string             `json:"id"`
+	Url    string             `json:"url"`
+	Params msgpack.RawMessage `json:"params"`
+}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
+
+func (m MsgpackCodec) DecodeRequest(data []byte) (*CodecRequest, error) {
+	req := &msgpackRequest{}
+	if err := m.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	var params interface{}
+	if len(req.Params) > 0 {
+		if err := m.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	return &CodecRequest{
+		Id:        req.Id,
+		Url:       req.Url,
+		Params:    params,
+		RawParams: req.Params,
+	}, nil
+}
+
+func (m MsgpackCodec) EncodeResponse(res *ClientResponse) ([]byte, error) {
+	return m.Marshal(res)
+}
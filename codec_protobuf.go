@@ -0,0 +1,196 @@
+package go_websocket
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Protobuf编解码器，二进制消息
+//
+// 请求信封：1 id string，2 url string，3 params bytes
+//
+// 响应信封：1 id string，2 url string，3 type string，4 code int64，5 msg string，6 data bytes，7 seq uint64，8 msg_id string
+//
+// 可靠发送的确认参数：1 msg_id string
+//
+// 负载需为 proto.Message，响应数据为 []byte 时原样发送，其他类型编码为 google.protobuf.Value
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (ProtobufCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case []byte:
+		return m, nil
+	}
+	return nil, ErrCodecUnsupported
+}
+
+// 解码，v 可以是 proto.Message、指向消息指针的指针或 *[]byte
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	case *[]byte:
+		*m = append([]byte(nil), data...)
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok := rv.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, m)
+		}
+	}
+	return ErrCodecUnsupported
+}
+
+// 解码请求，参数保留原始编码
+func (ProtobufCodec) DecodeRequest(data []byte) (*CodecRequest, error) {
+	req := &CodecRequest{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType || num > 3 {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			req.Id = string(v)
+		case 2:
+			req.Url = string(v)
+		case 3:
+			req.RawParams = append([]byte(nil), v...)
+		}
+	}
+	req.Params = req.RawParams
+	return req, nil
+}
+
+func (ProtobufCodec) EncodeResponse(res *ClientResponse) ([]byte, error) {
+	data, err := protobufData(res.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	for _, f := range []struct {
+		num protowire.Number
+		val string
+	}{{1, res.Id}, {2, res.Url}, {3, res.Type}} {
+		if len(f.val) > 0 {
+			buf = protowire.AppendTag(buf, f.num, protowire.BytesType)
+			buf = protowire.AppendString(buf, f.val)
+		}
+	}
+	buf = protowire.AppendTag(buf, 4, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, uint64(int64(res.Code)))
+	if len(res.Msg) > 0 {
+		buf = protowire.AppendTag(buf, 5, protowire.BytesType)
+		buf = protowire.AppendString(buf, res.Msg)
+	}
+	if data != nil {
+		buf = protowire.AppendTag(buf, 6, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, data)
+	}
+	if res.Seq > 0 {
+		buf = protowire.AppendTag(buf, 7, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, res.Seq)
+	}
+	if len(res.MsgId) > 0 {
+		buf = protowire.AppendTag(buf, 8, protowire.BytesType)
+		buf = protowire.AppendString(buf, res.MsgId)
+	}
+	return buf, nil
+}
+
+// 响应数据编码
+func protobufData(d interface{}) ([]byte, error) {
+	switch v := d.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case proto.Message:
+		return proto.Marshal(v)
+	}
+
+	//其他类型先转为通用结构再编码为 google.protobuf.Value
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	value, err := structpb.NewValue(generic)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(value)
+}
+
+// 解析确认参数
+func decodeProtobufAck(req IRequest) (AckParams, error) {
+	var params AckParams
+	var data []byte
+	if r, ok := req.(IRawRequest); ok {
+		data = r.GetRawParams()
+	}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return params, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return params, protowire.ParseError(n)
+			}
+			params.MsgId = string(v)
+			data = data[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return params, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return params, nil
+}
@@ -0,0 +1,238 @@
+package go_websocket
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 编码 protobuf 请求信封
+func protobufRequest(id, url string, params []byte) []byte {
+	var buf []byte
+	buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+	buf = protowire.AppendString(buf, id)
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendString(buf, url)
+	if params != nil {
+		buf = protowire.AppendTag(buf, 3, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, params)
+	}
+	return buf
+}
+
+func TestProtobufDecodeRequest(t *testing.T) {
+	params, _ := proto.Marshal(wrapperspb.String("hi"))
+	data := protobufRequest("1", "/echo", params)
+	//未知字段跳过
+	data = protowire.AppendTag(data, 9, protowire.VarintType)
+	data = protowire.AppendVarint(data, 42)
+	data = protowire.AppendTag(data, 10, protowire.BytesType)
+	data = protowire.AppendString(data, "x")
+	data = protowire.AppendTag(data, 11, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 7)
+
+	req, err := ProtobufCodec{}.DecodeRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Id != "1" || req.Url != "/echo" || string(req.RawParams) != string(params) {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	var v *wrapperspb.StringValue
+	if err := (ProtobufCodec{}).Unmarshal(req.RawParams, &v); err != nil || v.GetValue() != "hi" {
+		t.Fatalf("Unmarshal: %v %v", v, err)
+	}
+
+	if _, err := (ProtobufCodec{}).DecodeRequest(data[:len(data)-2]); err == nil {
+		t.Fatal("truncated request decoded")
+	}
+}
+
+func TestProtobufEncodeResponse(t *testing.T) {
+	res := &ClientResponse{Id: "1", Url: "/echo", Type: ResponseTypePush, Seq: 5, MsgId: "m1", Code: 200, Msg: "ok", Data: []byte{1, 2}}
+	data, err := ProtobufCodec{}.EncodeResponse(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := protobufFields(t, data)
+	if string(f[1].([]byte)) != "1" || string(f[2].([]byte)) != "/echo" || string(f[3].([]byte)) != "push" ||
+		f[4].(uint64) != 200 || string(f[5].([]byte)) != "ok" || string(f[6].([]byte)) != "\x01\x02" ||
+		f[7].(uint64) != 5 || string(f[8].([]byte)) != "m1" {
+		t.Fatalf("unexpected fields %v", f)
+	}
+
+	//其他类型的数据编码为 google.protobuf.Value
+	data, err = ProtobufCodec{}.EncodeResponse(&ClientResponse{Code: 400, Data: map[string]interface{}{"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = protobufFields(t, data)
+	if _, ok := f[7]; ok {
+		t.Fatalf("seq encoded: %v", f)
+	}
+	value := &structpb.Value{}
+	if err := proto.Unmarshal(f[6].([]byte), value); err != nil {
+		t.Fatal(err)
+	}
+	if value.GetStructValue().GetFields()["a"].GetNumberValue() != 1 {
+		t.Fatalf("unexpected data %v", value)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	type params struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}, CBORCodec{}} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := codec.Marshal(map[string]interface{}{
+				"id":     "1",
+				"url":    "/user",
+				"params": params{Name: "a", Age: 3},
+				"extra":  true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := codec.DecodeRequest(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Id != "1" || req.Url != "/user" {
+				t.Fatalf("unexpected request %+v", req)
+			}
+			var p params
+			if err := codec.Unmarshal(req.RawParams, &p); err != nil || p.Name != "a" || p.Age != 3 {
+				t.Fatalf("params %+v %v", p, err)
+			}
+
+			data, err = codec.EncodeResponse(&ClientResponse{Id: "1", Type: ResponseTypeReply, Seq: 5, MsgId: "m1", Code: 200, Msg: "ok", Data: p})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var res struct {
+				ClientResponse
+				Data params `json:"data"`
+			}
+			if err := codec.Unmarshal(data, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Id != "1" || res.Type != ResponseTypeReply || res.Seq != 5 || res.MsgId != "m1" || res.Code != 200 || res.Data != p {
+				t.Fatalf("unexpected response %+v", res)
+			}
+		})
+	}
+}
+
+// 注册回显路由的测试服务
+func newCodecServer(t *testing.T, opts ...Option) string {
+	t.Helper()
+	h := NewClientHandler()
+	h.Register("/echo", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		return NewOkClientRes(p), nil
+	})
+	Handle(h, "/proto", func(ctx context.Context, c *Client, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("hello " + req.GetValue()), nil
+	})
+	cm := NewClientManage(append([]Option{WithClientHandler(h)}, opts...)...)
+	return newTestServer(t, cm)
+}
+
+// 发送请求并读取回复
+func codecCall(t *testing.T, conn *websocket.Conn, codec Codec, req []byte) ClientResponse {
+	t.Helper()
+	if err := conn.WriteMessage(codec.MessageType(), req); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msgType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != codec.MessageType() {
+		t.Fatalf("message type %d", msgType)
+	}
+	var res ClientResponse
+	if err := codec.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestCodecSelection(t *testing.T) {
+	u := newCodecServer(t, WithSubprotocols(Subprotocol{Name: "v1.cbor", Codec: CBORCodec{}}))
+	req := map[string]interface{}{"id": "1", "url": "/echo", "params": "x"}
+
+	tests := []struct {
+		query        string
+		subprotocols []string
+		codec        Codec
+	}{
+		{"?codec=msgpack", nil, MsgpackCodec{}},
+		{"?codec=cbor", nil, CBORCodec{}},
+		{"?codec=json", nil, JSONCodec{}},
+		{"", []string{"v1.cbor"}, CBORCodec{}},
+		//子协议配置了编解码器时以子协议为准
+		{"?codec=msgpack", []string{"v1.cbor"}, CBORCodec{}},
+	}
+	for _, tt := range tests {
+		dialer := websocket.Dialer{Subprotocols: tt.subprotocols}
+		conn, _, err := dialer.Dial(u+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := tt.codec.Marshal(req)
+		if res := codecCall(t, conn, tt.codec, data); res.Id != "1" || res.Data != "x" {
+			t.Fatalf("%s %v: unexpected response %+v", tt.query, tt.subprotocols, res)
+		}
+		conn.Close()
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(u+"?codec=xml", nil)
+	if err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown codec: %v %v", resp, err)
+	}
+}
+
+func TestProtobufTypedHandle(t *testing.T) {
+	u := newCodecServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial(u+"?codec=protobuf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	params, _ := proto.Marshal(wrapperspb.String("ws"))
+	conn.WriteMessage(websocket.BinaryMessage, protobufRequest("1", "/proto", params))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := protobufFields(t, data)
+	if string(f[1].([]byte)) != "1" || f[4].(uint64) != 200 {
+		t.Fatalf("unexpected fields %v", f)
+	}
+	res := &wrapperspb.StringValue{}
+	if err := proto.Unmarshal(f[6].([]byte), res); err != nil || res.GetValue() != "hello ws" {
+		t.Fatalf("unexpected data %v %v", res, err)
+	}
+
+	//参数无法解码时返回400
+	conn.WriteMessage(websocket.BinaryMessage, protobufRequest("2", "/proto", []byte{0xff}))
+	_, data, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := protobufFields(t, data); string(f[1].([]byte)) != "2" || f[4].(uint64) != 400 {
+		t.Fatalf("unexpected fields %v", f)
+	}
+}
@@ -0,0 +1,131 @@
+package go_websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 压缩统计，只统计压缩发送的消息
+type CompressionStats struct {
+	Messages     uint64 //压缩发送的消息数
+	PayloadBytes uint64 //压缩前的字节数
+	WireBytes    uint64 //实际写出的字节数，包含帧头
+}
+
+// 节省的字节数
+func (s CompressionStats) SavedBytes() int64 {
+	return int64(s.PayloadBytes) - int64(s.WireBytes)
+}
+
+// 压缩率，实际写出字节数与压缩前字节数之比
+func (s CompressionStats) Ratio() float64 {
+	if s.PayloadBytes <= 0 {
+		return 0
+	}
+	return float64(s.WireBytes) / float64(s.PayloadBytes)
+}
+
+type compressionCounter struct {
+	messages     uint64
+	payloadBytes uint64
+	wireBytes    uint64
+}
+
+func (cc *compressionCounter) add(payload int, wire uint64) {
+	atomic.AddUint64(&cc.messages, 1)
+	atomic.AddUint64(&cc.payloadBytes, uint64(payload))
+	atomic.AddUint64(&cc.wireBytes, wire)
+}
+
+func (cc *compressionCounter) stats() CompressionStats {
+	return CompressionStats{
+		Messages:     atomic.LoadUint64(&cc.messages),
+		PayloadBytes: atomic.LoadUint64(&cc.payloadBytes),
+		WireBytes:    atomic.LoadUint64(&cc.wireBytes),
+	}
+}
+
+// 所有客户端的压缩统计
+func (cm *ClientManage) GetCompressionStats() CompressionStats {
+	return cm.compression.stats()
+}
+
+// 客户端的压缩统计
+func (c *Client) GetCompressionStats() CompressionStats {
+	return c.compression.stats()
+}
+
+// 是否与客户端协商了压缩
+func (c *Client) CompressionNegotiated() bool {
+	return c.wire != nil
+}
+
+// 开启或关闭该客户端的压缩，未协商压缩时无效
+func (c *Client) SetCompression(enable bool) {
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&c.compress, v)
+}
+
+// 是否压缩该客户端的消息
+func (c *Client) IsCompressionEnabled() bool {
+	return c.wire != nil && atomic.LoadInt32(&c.compress) == 1
+}
+
+// 消息是否需要压缩，小于阈值的消息不压缩
+func (c *Client) shouldCompress(size int) bool {
+	return c.IsCompressionEnabled() && size >= c.clientManage.opts.CompressionThreshold
+}
+
+// 客户端是否请求了 permessage-deflate
+func offersCompression(r *http.Request) bool {
+	for _, v := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 统计写出字节数的连接
+type countingConn struct {
+	net.Conn
+	written uint64
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	atomic.AddUint64(&cc.written, uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) Written() uint64 {
+	return atomic.LoadUint64(&cc.written)
+}
+
+// 升级时把劫持的连接替换为 countingConn
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, brw, nil
+}
@@ -0,0 +1,82 @@
+package go_websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompression(t *testing.T) {
+	cm := NewClientManage(WithCompression(6, 512))
+	u := newTestServer(t, cm)
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, _, err := dialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var c *Client
+	waitFor(t, 3*time.Second, func() bool {
+		if list := cm.GetClientList(); len(list) > 0 {
+			c = cm.GetClientByID(list[0])
+		}
+		return c != nil
+	})
+	if !c.CompressionNegotiated() || !c.IsCompressionEnabled() {
+		t.Fatal("compression not negotiated")
+	}
+
+	send := func(data string) {
+		t.Helper()
+		msg, _ := NewOkClientRes(data).GetBytes()
+		cm.SendClientMsg(msg, c.GetID())
+		if res := readPush(t, conn); res.Data != data {
+			t.Fatalf("unexpected push %+v", res)
+		}
+	}
+
+	//小于阈值的消息不压缩，不统计
+	send("small")
+	if s := c.GetCompressionStats(); s.Messages != 0 {
+		t.Fatalf("small message counted %+v", s)
+	}
+
+	send(strings.Repeat("a", 10000))
+	waitFor(t, 3*time.Second, func() bool {
+		return c.GetCompressionStats().Messages == 1
+	})
+	s := c.GetCompressionStats()
+	if s.PayloadBytes < 10000 || s.SavedBytes() <= 0 || s.Ratio() >= 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if ms := cm.GetCompressionStats(); ms != s {
+		t.Fatalf("manager stats %+v, client stats %+v", ms, s)
+	}
+
+	//关闭后不再压缩
+	c.SetCompression(false)
+	send(strings.Repeat("b", 10000))
+	if s := c.GetCompressionStats(); s.Messages != 1 {
+		t.Fatalf("disabled compression counted %+v", s)
+	}
+}
+
+func TestCompressionNotNegotiated(t *testing.T) {
+	cm := NewClientManage(WithCompression(6, 0))
+	u := newTestServer(t, cm)
+	conn, c := dialClient(t, cm, u)
+
+	c.SetCompression(true)
+	if c.CompressionNegotiated() || c.IsCompressionEnabled() {
+		t.Fatal("compression enabled without negotiation")
+	}
+	msg, _ := NewOkClientRes(strings.Repeat("a", 10000)).GetBytes()
+	cm.SendClientMsg(msg, c.GetID())
+	readPush(t, conn)
+	if s := cm.GetCompressionStats(); s.Messages != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
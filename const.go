@@ -2,6 +2,7 @@ package go_websocket
 
 import "time"
 
+// 默认配置，可通过 Option 按管理器覆盖
 const (
 	ReadLimit         = 1024
 	ReadDeadline      = 10 * time.Second
@@ -10,4 +11,17 @@ const (
 	PingMessage       = ""
 	ReadBufferSize    = 1024
 	WriteBufferSize   = 1024
+	SendBufferSize    = 256
+	CloseCode         = 1001
+	CloseReason       = "going away"
+	CloseTimeout      = 5 * time.Second
+
+	CompressionLevel     = 1    //压缩级别，对应 compress/flate
+	CompressionThreshold = 1024 //小于该字节数的消息不压缩
+
+	ResumeBufferSize = 128 //每个会话缓存的已发送消息数
+
+	AckRoute = "/ack" //建议的可靠发送确认路由，需通过 WithAckRoute 开启
+
+	HookQueueSize = 256 //每个客户端等待执行的消息、错误回调数
 )
@@ -0,0 +1,78 @@
+package go_websocket
+
+import "context"
+
+type requestInfoKey struct{}
+
+// 请求信息，随上下文传递给处理器和日志
+type RequestInfo struct {
+	Id       string  //请求ID
+	Url      string  //请求路由
+	ClientId string  //客户端ID
+	SystemId string  //系统ID
+	Client   *Client //客户端
+}
+
+// 客户端上下文，只带客户端信息
+func newClientContext(parent context.Context, c *Client) context.Context {
+	return context.WithValue(parent, requestInfoKey{}, &RequestInfo{
+		ClientId: c.GetID(),
+		SystemId: c.GetSystemId(),
+		Client:   c,
+	})
+}
+
+// 创建请求上下文
+func NewRequestContext(parent context.Context, c *Client, req IRequest) context.Context {
+	info := &RequestInfo{
+		Url:      req.GetUrl(),
+		ClientId: c.GetID(),
+		SystemId: c.GetSystemId(),
+		Client:   c,
+	}
+	if ri, ok := req.(IRequestId); ok {
+		info.Id = ri.GetId()
+	}
+	return context.WithValue(parent, requestInfoKey{}, info)
+}
+
+// 获取请求信息
+func RequestInfoFromContext(ctx context.Context) (*RequestInfo, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info, ok
+}
+
+// 请求ID
+func RequestIdFromContext(ctx context.Context) string {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		return info.Id
+	}
+	return ""
+}
+
+// 客户端ID
+func ClientIdFromContext(ctx context.Context) string {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		return info.ClientId
+	}
+	return ""
+}
+
+// 系统ID
+func SystemIdFromContext(ctx context.Context) string {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		return info.SystemId
+	}
+	return ""
+}
+
+// 请求路由
+func RouteFromContext(ctx context.Context) string {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		return info.Url
+	}
+	return ""
+}
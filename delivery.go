@@ -0,0 +1,312 @@
+package go_websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// 未送达原因
+type DropReason string
+
+const (
+	DropReasonOffline   DropReason = "offline"    //客户端不在线
+	DropReasonQueueFull DropReason = "queue_full" //发送队列已满
+	DropReasonFiltered  DropReason = "filtered"   //被过滤
+	DropReasonError     DropReason = "error"      //格式化或编码失败
+)
+
+// 未送达的客户端
+type DeliveryDrop struct {
+	ClientId string     `json:"client_id"`
+	Reason   DropReason `json:"reason"`
+	Err      error      `json:"-"`
+}
+
+// 投递结果
+type DeliveryReport struct {
+	Targeted []string       `json:"targeted"` //目标客户端
+	Enqueued []string       `json:"enqueued"` //已进入发送队列的客户端
+	Dropped  []DeliveryDrop `json:"dropped"`  //未送达的客户端及原因
+
+	Remote       []string `json:"remote,omitempty"`        //不在本节点、转发给其他节点的客户端
+	Forwarded    bool     `json:"forwarded"`               //是否已转发给其他节点
+	ForwardError string   `json:"forward_error,omitempty"` //转发失败原因
+	Stored       []string `json:"stored,omitempty"`        //保存了离线消息的键
+
+	offlineUsers []string //本节点没有客户端的用户
+}
+
+func newDeliveryReport() *DeliveryReport {
+	return &DeliveryReport{
+		Targeted: make([]string, 0),
+		Enqueued: make([]string, 0),
+		Dropped:  make([]DeliveryDrop, 0),
+	}
+}
+
+// 是否至少投递给了一个客户端
+func (r *DeliveryReport) Delivered() bool {
+	return len(r.Enqueued) > 0
+}
+
+func (r *DeliveryReport) drop(clientId string, reason DropReason, err error) {
+	r.Dropped = append(r.Dropped, DeliveryDrop{
+		ClientId: clientId,
+		Reason:   reason,
+		Err:      err,
+	})
+}
+
+// 发送目标，多个目标合并去重，同一客户端只发送一次
+type Target struct {
+	Clients   []string             //客户端ID
+	Groups    []string             //组
+	Systems   []string             //系统
+	Users     []string             //用户
+	Broadcast bool                 //所有客户端
+	Filter    func(c *Client) bool //返回false的客户端不发送
+}
+
+// 按目标发送，返回投递结果
+//
+// 配置了消息总线时同时转发给其他节点，投递结果只包含本节点的客户端
+func (cm *ClientManage) Send(msg []byte, target Target) *DeliveryReport {
+	report := cm.deliver(msg, target)
+	cm.storeOffline(msg, false, target, report)
+	cm.forward(msg, target, false, report)
+	return report
+}
+
+// 按目标发送二进制消息，数据原样发送，不经过格式化和二进制信封
+func (cm *ClientManage) SendBinary(data []byte, target Target) *DeliveryReport {
+	report := cm.deliverBinary(data, target)
+	cm.storeOffline(data, true, target, report)
+	cm.forward(data, target, true, report)
+	return report
+}
+
+// 投递给本节点的客户端
+func (cm *ClientManage) deliver(msg []byte, target Target) *DeliveryReport {
+	report := newDeliveryReport()
+	clients := cm.resolve(target, report)
+
+	//按子协议、编解码器分组，每组只格式化、编码一次；有单独格式化方法或带序号的客户端单独发送
+	shared := make(map[encodingKey][]*Client)
+	order := make([]encodingKey, 0)
+	for _, c := range clients {
+		if c.hasOwnResponseFormatFunc() || c.session != nil {
+			report.result(c, c.SendMsg(msg))
+			continue
+		}
+		key := encodingKey{subprotocol: c.subprotocol}
+		if c.codec != nil {
+			key.codec = c.codec.Name()
+		}
+		if _, ok := shared[key]; !ok {
+			order = append(order, key)
+		}
+		shared[key] = append(shared[key], c)
+	}
+
+	for _, key := range order {
+		cm.fanout(msg, shared[key], report)
+	}
+	return report
+}
+
+// 投递二进制消息给本节点的客户端
+func (cm *ClientManage) deliverBinary(data []byte, target Target) *DeliveryReport {
+	report := newDeliveryReport()
+	clients := cm.resolve(target, report)
+	if len(clients) <= 0 {
+		return report
+	}
+
+	om := newOutMessage(websocket.BinaryMessage, data)
+	if len(clients) > 1 {
+		pm, err := newPreparedOutMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			Log.Error(context.Background(), "PrepareMessage Error ", err)
+			for _, c := range clients {
+				report.drop(c.GetID(), DropReasonError, err)
+			}
+			return report
+		}
+		om = pm
+	}
+
+	for _, c := range clients {
+		report.result(c, c.enqueueLive(om))
+	}
+	return report
+}
+
+// 解析发送目标，合并去重并过滤，不在线和被过滤的客户端记入投递结果
+//
+// 配置了消息总线时不在本节点的客户端记为 Remote
+func (cm *ClientManage) resolve(target Target, report *DeliveryReport) []*Client {
+	seen := make(map[string]struct{})
+	clients := make([]*Client, 0)
+
+	add := func(c *Client) {
+		if _, ok := seen[c.GetID()]; ok {
+			return
+		}
+		seen[c.GetID()] = struct{}{}
+		report.Targeted = append(report.Targeted, c.GetID())
+		if target.Filter != nil && !target.Filter(c) {
+			report.drop(c.GetID(), DropReasonFiltered, nil)
+			return
+		}
+		clients = append(clients, c)
+	}
+
+	if target.Broadcast {
+		for _, c := range cm.clients.snapshot() {
+			add(c)
+		}
+	}
+	for _, g := range target.Groups {
+		for _, c := range cm.indexMembers(cm.groups, g) {
+			add(c)
+		}
+	}
+	for _, s := range target.Systems {
+		for _, c := range cm.indexMembers(cm.systems, s) {
+			add(c)
+		}
+	}
+	for _, uid := range target.Users {
+		members := cm.indexMembers(cm.users, uid)
+		if len(members) <= 0 {
+			report.offlineUsers = append(report.offlineUsers, uid)
+			continue
+		}
+		for _, c := range members {
+			add(c)
+		}
+	}
+	for _, id := range target.Clients {
+		c := cm.GetClientByID(id)
+		if c == nil {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				report.Targeted = append(report.Targeted, id)
+				if cm.opts.Broker != nil {
+					report.Remote = append(report.Remote, id)
+				} else {
+					report.drop(id, DropReasonOffline, ErrClientClosed)
+				}
+			}
+			continue
+		}
+		add(c)
+	}
+	return clients
+}
+
+// 编码方式相同的客户端分组
+type encodingKey struct {
+	subprotocol *Subprotocol
+	codec       string
+}
+
+// 群发给编码方式相同的客户端，消息只格式化、编码一次，通过 PreparedMessage 共用帧
+//
+// 只有使用默认格式化、编码方法时共用，自定义的方法按客户端分别调用
+func (cm *ClientManage) fanout(msg []byte, clients []*Client, report *DeliveryReport) {
+	if len(clients) == 1 || !cm.sharedEncoding(clients[0].subprotocol) {
+		for _, c := range clients {
+			report.result(c, c.SendMsg(msg))
+		}
+		return
+	}
+
+	om, err := cm.preparePush(msg, clients[0].subprotocol, clients[0].codec)
+	if err != nil {
+		Log.Error(context.Background(), "PreparePush Error ", err)
+		for _, c := range clients {
+			report.drop(c.GetID(), DropReasonError, err)
+		}
+		return
+	}
+
+	for _, c := range clients {
+		report.result(c, c.enqueueLive(om))
+	}
+}
+
+// 是否可以共用格式化、编码结果，管理器和子协议都使用默认方法时才共用
+func (cm *ClientManage) sharedEncoding(sp *Subprotocol) bool {
+	if !cm.defaultRes {
+		return false
+	}
+	return sp == nil || (sp.ResFormatFn == nil && sp.ResEncodeFn == nil)
+}
+
+// 格式化、编码推送消息并预编码
+func (cm *ClientManage) preparePush(msg []byte, sp *Subprotocol, codec Codec) (om *outMessage, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			Log.Error(context.Background(), "PreparePush Panic ", e)
+			om, err = nil, fmt.Errorf("prepare push panic: %v", e)
+		}
+	}()
+
+	res, err := cm.DefaultResponseFormatFunc()(nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := res.(IPushResponse); ok {
+		p.SetPush()
+	}
+
+	data, msgType, err := cm.encodeResponse(nil, sp, codec, res)
+	if err != nil {
+		return nil, err
+	}
+	return newPreparedOutMessage(msgType, data)
+}
+
+// 响应编码，返回编码结果和消息类型
+//
+// 二进制响应使用二进制信封，选择了编解码器时使用编解码器，其余优先使用子协议配置
+func (cm *ClientManage) encodeResponse(c *Client, sp *Subprotocol, codec Codec, res IResponse) ([]byte, int, error) {
+	if br, ok := res.(*BinaryResponse); ok {
+		data, err := cm.opts.BinaryEnvelope.Encode(br)
+		return data, websocket.BinaryMessage, err
+	}
+	if cr, ok := res.(*ClientResponse); ok && codec != nil {
+		data, err := codec.EncodeResponse(cr)
+		return data, codec.MessageType(), err
+	}
+	if sp != nil && sp.ResEncodeFn != nil {
+		data, err := sp.ResEncodeFn(c, res)
+		return data, messageTypeOf(res), err
+	}
+	data, err := res.GetBytes()
+	return data, messageTypeOf(res), err
+}
+
+// 记录单个客户端的发送结果
+func (r *DeliveryReport) result(c *Client, err error) {
+	if err != nil {
+		r.drop(c.GetID(), dropReason(err), err)
+		return
+	}
+	r.Enqueued = append(r.Enqueued, c.GetID())
+}
+
+// 发送错误对应的未送达原因
+func dropReason(err error) DropReason {
+	switch {
+	case errors.Is(err, ErrClientClosed):
+		return DropReasonOffline
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrSlowConsumer):
+		return DropReasonQueueFull
+	}
+	return DropReasonError
+}
@@ -0,0 +1,101 @@
+package go_websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcastCustomFormatterGetsClient(t *testing.T) {
+	cm := NewClientManage()
+	cm.SetResponseFormatFunc(func(c *Client, data []byte) (IResponse, error) {
+		return NewOkClientRes(c.GetID()), nil
+	})
+	u := newTestServer(t, cm)
+
+	conns := make([]*websocket.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitFor(t, 3*time.Second, func() bool {
+		return cm.GetClientCount() == 2
+	})
+
+	report := cm.Broadcast([]byte("x"))
+	if len(report.Enqueued) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	//格式化方法按客户端调用，每个客户端收到自己的ID
+	seen := make(map[string]bool)
+	for _, conn := range conns {
+		res := readPush(t, conn)
+		id, _ := res.Data.(string)
+		if cm.GetClientByID(id) == nil || seen[id] {
+			t.Fatalf("unexpected push %+v", res)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSendDedupAndDropReasons(t *testing.T) {
+	cm := NewClientManage(WithSendBufferSize(1))
+	newClient := func(id, system string, groups ...string) *Client {
+		c := NewClient(id, system, nil, cm)
+		cm.AddGroupsByClient(c, groups...)
+		cm.Register(c)
+		return c
+	}
+	a := newClient("a", "s1", "g1", "g2")
+	b := newClient("b", "s2", "g1")
+	newClient("f", "s1", "g2")
+	//b 的发送队列已满
+	msg, _ := NewOkClientRes("x").GetBytes()
+	if err := b.SendMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	report := cm.Send(msg, Target{
+		Clients: []string{"a", "gone", "a", "gone"},
+		Groups:  []string{"g1", "g2"},
+		Systems: []string{"s1"},
+		Filter: func(c *Client) bool {
+			return c.GetID() != "f"
+		},
+	})
+
+	targeted := make(map[string]int)
+	for _, id := range report.Targeted {
+		targeted[id]++
+	}
+	if len(report.Targeted) != 4 || targeted["a"] != 1 || targeted["b"] != 1 || targeted["f"] != 1 || targeted["gone"] != 1 {
+		t.Fatalf("targeted %v", report.Targeted)
+	}
+	if len(report.Enqueued) != 1 || report.Enqueued[0] != "a" || len(a.send) != 1 {
+		t.Fatalf("enqueued %v, queue %d", report.Enqueued, len(a.send))
+	}
+	reasons := make(map[string]DropReason)
+	for _, d := range report.Dropped {
+		if _, ok := reasons[d.ClientId]; ok {
+			t.Fatalf("dropped twice %v", report.Dropped)
+		}
+		reasons[d.ClientId] = d.Reason
+	}
+	want := map[string]DropReason{"b": DropReasonQueueFull, "f": DropReasonFiltered, "gone": DropReasonOffline}
+	if len(reasons) != len(want) {
+		t.Fatalf("dropped %+v", report.Dropped)
+	}
+	for id, reason := range want {
+		if reasons[id] != reason {
+			t.Fatalf("dropped %+v", report.Dropped)
+		}
+	}
+	if !report.Delivered() {
+		t.Fatal("not delivered")
+	}
+}
@@ -0,0 +1,110 @@
+package go_websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// 错误，会自动转为错误响应返回给客户端
+type Error struct {
+	Status  int         //类HTTP状态码
+	Code    string      //错误码
+	Msg     string      //错误信息
+	Details interface{} //错误详情
+}
+
+func NewError(status int, code string, msg string) *Error {
+	return &Error{
+		Status: status,
+		Code:   code,
+		Msg:    msg,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// 复制并设置错误信息
+func (e *Error) WithMsg(msg string) *Error {
+	ne := *e
+	ne.Msg = msg
+	return &ne
+}
+
+// 复制并设置错误详情
+func (e *Error) WithDetails(details interface{}) *Error {
+	ne := *e
+	ne.Details = details
+	return &ne
+}
+
+// 复制后的错误与原错误视为同一错误，errors.Is 可用
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Status == t.Status && e.Code == t.Code
+}
+
+var (
+	ErrBadRequest  = NewError(http.StatusBadRequest, "bad_request", "bad request")
+	ErrNotFound    = NewError(http.StatusNotFound, "not_found", "handler not found")
+	ErrInternal    = NewError(http.StatusInternalServerError, "internal_error", "internal error")
+	ErrRateLimited = NewError(http.StatusTooManyRequests, "rate_limited", "rate limited")
+	ErrForbidden   = NewError(http.StatusForbidden, "forbidden", "forbidden")
+	ErrTimeout     = NewError(http.StatusGatewayTimeout, "timeout", "request timeout")
+)
+
+// 任意错误转为 *Error，未知错误视为500
+//
+// 未知错误的内容可能包含内部信息，不返回给客户端
+func AsError(err error) *Error {
+	e, _ := asError(err)
+	return e
+}
+
+// 转为 *Error，ok 为 false 表示未知错误
+func asError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout, true
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return NewError(authErr.Status, "unauthorized", authErr.Msg), true
+	}
+	return ErrInternal, false
+}
+
+// 错误响应数据
+type ErrorData struct {
+	Code    string      `json:"code"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// 错误响应格式化方法
+type ErrorResponseFunc func(c *Client, req IRequest, err error) IResponse
+
+// 默认的错误响应，code 为状态码，data 为错误码和详情
+func DefaultErrorResponseFunc(c *Client, req IRequest, err error) IResponse {
+	return NewErrClientResFromError(err)
+}
+
+// 根据错误生成错误响应
+func NewErrClientResFromError(err error) *ClientResponse {
+	e := AsError(err)
+	res := NewErrClientRes(e.Msg, ErrorData{
+		Code:    e.Code,
+		Details: e.Details,
+	})
+	if e.Status > 0 {
+		res.Code = e.Status
+	}
+	return res
+}
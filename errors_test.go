@@ -0,0 +1,80 @@
+package go_websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAsError(t *testing.T) {
+	notFound := NewError(404, "user_not_found", "用户不存在")
+	tests := []struct {
+		name string
+		err  error
+		want *Error
+	}{
+		{"typed", notFound, notFound},
+		{"wrapped", fmt.Errorf("wrap: %w", ErrForbidden), ErrForbidden},
+		{"timeout", context.DeadlineExceeded, ErrTimeout},
+		{"unknown", errors.New("dial tcp 10.0.0.1:3306: password=secret"), ErrInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AsError(tt.err); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	res := NewErrClientResFromError(errors.New("secret"))
+	if res.Code != 500 || res.Msg != ErrInternal.Msg {
+		t.Fatalf("unexpected response %+v", res)
+	}
+}
+
+// 并发安全的日志输出
+type logBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestSendErrorLogsInternalError(t *testing.T) {
+	buf := &logBuffer{}
+	Log.log.SetOutput(buf)
+	defer Log.log.SetOutput(os.Stdout)
+
+	//AsError 不记录日志
+	AsError(errors.New("secret1"))
+	NewErrClientResFromError(errors.New("secret1"))
+	if strings.Contains(buf.String(), "secret1") {
+		t.Fatalf("AsError logged: %s", buf.String())
+	}
+
+	c := NewClient("c1", "s", nil, NewClientManage())
+	c.SendError(&ClientRequest{Id: "1"}, ErrForbidden.WithMsg("secret2"))
+	if strings.Contains(buf.String(), "secret2") {
+		t.Fatalf("typed error logged: %s", buf.String())
+	}
+	c.SendError(&ClientRequest{Id: "1"}, errors.New("secret3"))
+	out := buf.String()
+	if strings.Count(out, "secret3") != 1 || !strings.Contains(out, `"client_id":"c1"`) {
+		t.Fatalf("unexpected log: %s", out)
+	}
+}
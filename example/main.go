@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	go_websocket "github.com/lackone/go-websocket"
 	"log"
@@ -11,7 +13,7 @@ func main() {
 	manage := go_websocket.NewClientManage()
 	go manage.Run()
 
-	go_websocket.WsClientHandler.Register("/test", func(client *go_websocket.Client, params interface{}) (go_websocket.IResponse, error) {
+	go_websocket.WsClientHandler.Register("/test", func(ctx context.Context, client *go_websocket.Client, params interface{}) (go_websocket.IResponse, error) {
 		fmt.Println(params)
 		return go_websocket.NewOkClientRes(map[string]interface{}{
 			"test": "test",
@@ -28,7 +30,7 @@ func main() {
 			"client_id": client.GetID(),
 		})
 
-		client.SendResponse(res)
+		client.SendPush(res)
 	})
 
 	http.HandleFunc("/broadcast", func(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +40,9 @@ func main() {
 			"msg": msg,
 		}).GetBytes()
 
-		manage.Broadcast(bytes)
+		report := manage.Broadcast(bytes)
+
+		json.NewEncoder(w).Encode(report)
 	})
 
 	http.HandleFunc("/push_system", func(w http.ResponseWriter, r *http.Request) {
@@ -49,7 +53,9 @@ func main() {
 			"msg": msg,
 		}).GetBytes()
 
-		manage.SendSystemMsg(bytes, systemId)
+		report := manage.SendSystemMsg(bytes, systemId)
+
+		json.NewEncoder(w).Encode(report)
 	})
 
 	http.HandleFunc("/push_group", func(w http.ResponseWriter, r *http.Request) {
@@ -60,7 +66,9 @@ func main() {
 			"msg": msg,
 		}).GetBytes()
 
-		manage.SendGroupMsg(bytes, group)
+		report := manage.SendGroupMsg(bytes, group)
+
+		json.NewEncoder(w).Encode(report)
 	})
 
 	http.HandleFunc("/push_client", func(w http.ResponseWriter, r *http.Request) {
@@ -71,7 +79,9 @@ func main() {
 			"msg": msg,
 		}).GetBytes()
 
-		manage.SendClientMsg(bytes, clientId)
+		report := manage.SendClientMsg(bytes, clientId)
+
+		json.NewEncoder(w).Encode(report)
 	})
 
 	http.HandleFunc("/add_group", func(w http.ResponseWriter, r *http.Request) {
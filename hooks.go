@@ -0,0 +1,164 @@
+package go_websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 生命周期回调，同一客户端的回调按发生顺序在单独的协程中逐个执行，不阻塞读写循环
+//
+// 回调 panic 时记录日志，不影响后续回调。OnMessage、OnError 等待执行的数量超过
+// HookQueueSize 时丢弃，可通过 GetHookDroppedCount 查看，其他回调不丢弃
+type Hooks struct {
+	//客户端注册后，连接时加入的组可通过 c.GetGroups() 获取
+	OnConnect func(c *Client)
+	//客户端删除后，code、reason 为关闭码和原因，duration 为连接时长
+	OnDisconnect func(c *Client, code int, reason string, duration time.Duration)
+	//已注册的客户端加入组，只包含新加入的组
+	OnGroupJoin func(c *Client, groups []string)
+	//已注册的客户端离开组，只包含原来所在的组
+	OnGroupLeave func(c *Client, groups []string)
+	//收到消息，解析前调用
+	OnMessage func(c *Client, msgType int, data []byte)
+	//读取或处理消息出错
+	OnError func(c *Client, err error)
+}
+
+// 客户端的回调队列，保证顺序执行
+type hookQueue struct {
+	tasks   []func()
+	running bool
+	lock    sync.Mutex
+}
+
+// 加入队列，没有执行协程时启动一个，队列为空时协程退出
+//
+// droppable 为 true 时，队列长度超过 HookQueueSize 则丢弃
+func (cm *ClientManage) runHook(c *Client, fn func(), droppable bool) {
+	q := c.hooks
+	q.lock.Lock()
+	if size := cm.opts.HookQueueSize; droppable && size > 0 && len(q.tasks) >= size {
+		q.lock.Unlock()
+		atomic.AddUint64(&cm.hookDropped, 1)
+		return
+	}
+	q.tasks = append(q.tasks, fn)
+	if q.running {
+		q.lock.Unlock()
+		return
+	}
+	q.running = true
+	cm.handlers.Add(1)
+	q.lock.Unlock()
+
+	go func() {
+		defer cm.handlers.Done()
+		for {
+			q.lock.Lock()
+			if len(q.tasks) <= 0 {
+				q.running = false
+				q.lock.Unlock()
+				return
+			}
+			task := q.tasks[0]
+			q.tasks[0] = nil
+			q.tasks = q.tasks[1:]
+			q.lock.Unlock()
+
+			cm.callHook(c, task)
+		}
+	}()
+}
+
+// 执行回调，panic 时记录日志
+func (cm *ClientManage) callHook(c *Client, task func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			Log.Error(c.ctx, "Hook Panic ", err)
+		}
+	}()
+	task()
+}
+
+func (cm *ClientManage) onConnect(c *Client) {
+	if fn := cm.opts.Hooks.OnConnect; fn != nil {
+		cm.runHook(c, func() {
+			fn(c)
+		}, false)
+	}
+}
+
+func (cm *ClientManage) onDisconnect(c *Client) {
+	if fn := cm.opts.Hooks.OnDisconnect; fn != nil {
+		code, reason := c.closeStatus()
+		duration := time.Since(c.connectedAt)
+		cm.runHook(c, func() {
+			fn(c, code, reason, duration)
+		}, false)
+	}
+}
+
+func (cm *ClientManage) onGroupJoin(c *Client, groups []string) {
+	if fn := cm.opts.Hooks.OnGroupJoin; fn != nil && len(groups) > 0 {
+		cm.runHook(c, func() {
+			fn(c, groups)
+		}, false)
+	}
+}
+
+func (cm *ClientManage) onGroupLeave(c *Client, groups []string) {
+	if fn := cm.opts.Hooks.OnGroupLeave; fn != nil && len(groups) > 0 {
+		cm.runHook(c, func() {
+			fn(c, groups)
+		}, false)
+	}
+}
+
+func (cm *ClientManage) onMessage(c *Client, msgType int, data []byte) {
+	if fn := cm.opts.Hooks.OnMessage; fn != nil {
+		cm.runHook(c, func() {
+			fn(c, msgType, data)
+		}, true)
+	}
+}
+
+func (cm *ClientManage) onError(c *Client, err error) {
+	if fn := cm.opts.Hooks.OnError; fn != nil {
+		cm.runHook(c, func() {
+			fn(c, err)
+		}, true)
+	}
+}
+
+// 队列满时丢弃的 OnMessage、OnError 回调数
+func (cm *ClientManage) GetHookDroppedCount() uint64 {
+	return atomic.LoadUint64(&cm.hookDropped)
+}
+
+// 记录关闭码和原因，只记录第一次
+func (c *Client) setCloseStatus(code int, reason string) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.closeCode == 0 {
+		c.closeCode = code
+		c.closeReason = reason
+	}
+}
+
+// 关闭码和原因，未记录时为1005
+func (c *Client) closeStatus() (int, string) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.closeCode == 0 {
+		return websocket.CloseNoStatusReceived, ""
+	}
+	return c.closeCode, c.closeReason
+}
+
+// 连接时间，恢复会话时为最初连接的时间
+func (c *Client) GetConnectedAt() time.Time {
+	return c.connectedAt
+}
@@ -0,0 +1,41 @@
+package go_websocket
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHookQueueBounded(t *testing.T) {
+	release := make(chan struct{})
+	var handled int64
+	cm := NewClientManage(
+		WithHookQueueSize(4),
+		WithHooks(Hooks{
+			OnMessage: func(c *Client, msgType int, data []byte) {
+				<-release
+				atomic.AddInt64(&handled, 1)
+			},
+		}),
+	)
+	u := newTestServer(t, cm)
+	conn, _ := dialClient(t, cm, u)
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"url":"/none"}`))
+	}
+	waitFor(t, 3*time.Second, func() bool {
+		return cm.GetHookDroppedCount() >= total-6
+	})
+	close(release)
+
+	waitFor(t, 3*time.Second, func() bool {
+		return uint64(atomic.LoadInt64(&handled))+cm.GetHookDroppedCount() == total
+	})
+	if n := atomic.LoadInt64(&handled); n > 6 {
+		t.Fatalf("handled %d hooks with queue size 4", n)
+	}
+}
@@ -0,0 +1,200 @@
+package go_websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 节点ID环境变量
+const NodeIdEnv = "WS_NODE_ID"
+
+var (
+	defaultIDGenerator     IDGenerator
+	defaultIDGeneratorErr  error
+	defaultIDGeneratorOnce sync.Once
+	defaultIDGeneratorLock sync.RWMutex
+)
+
+// ID生成器
+type IDGenerator interface {
+	Generate() string
+}
+
+// 函数形式的ID生成器
+type IDGeneratorFunc func() string
+
+func (f IDGeneratorFunc) Generate() string {
+	return f()
+}
+
+// 默认ID生成器，设置了环境变量时使用环境变量，否则根据本机网卡生成，失败时使用节点0
+//
+// 环境变量不是 0-1023 的整数时 panic，避免多个节点的客户端ID重复
+func DefaultIDGenerator() IDGenerator {
+	defaultIDGeneratorOnce.Do(func() {
+		var g IDGenerator
+		var err error
+		if os.Getenv(NodeIdEnv) != "" {
+			g, err = NewSnowflakeGeneratorFromEnv(NodeIdEnv)
+			if err != nil {
+				defaultIDGeneratorErr = fmt.Errorf("invalid %s: %w", NodeIdEnv, err)
+				return
+			}
+		} else {
+			g, err = NewSnowflakeGeneratorFromInterface("")
+			if err != nil {
+				Log.Warn(context.Background(), "DefaultIDGenerator Fallback Node 0 ", err)
+				g, _ = NewSnowflakeGenerator(0)
+			}
+		}
+		defaultIDGeneratorLock.Lock()
+		if defaultIDGenerator == nil {
+			defaultIDGenerator = g
+		}
+		defaultIDGeneratorLock.Unlock()
+	})
+	defaultIDGeneratorLock.RLock()
+	defer defaultIDGeneratorLock.RUnlock()
+	if defaultIDGenerator == nil && defaultIDGeneratorErr != nil {
+		panic(defaultIDGeneratorErr)
+	}
+	return defaultIDGenerator
+}
+
+// 设置默认ID生成器
+func SetDefaultIDGenerator(g IDGenerator) {
+	defaultIDGeneratorOnce.Do(func() {})
+	defaultIDGeneratorLock.Lock()
+	defer defaultIDGeneratorLock.Unlock()
+	defaultIDGenerator = g
+}
+
+// 雪花ID生成器
+type SnowflakeGenerator struct {
+	node *snowflake.Node
+}
+
+// 指定节点ID，范围 0-1023
+func NewSnowflakeGenerator(nodeId int64) (*SnowflakeGenerator, error) {
+	node, err := snowflake.NewNode(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	return &SnowflakeGenerator{node: node}, nil
+}
+
+// 从环境变量读取节点ID
+func NewSnowflakeGeneratorFromEnv(key string) (*SnowflakeGenerator, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil, fmt.Errorf("env %s is empty", key)
+	}
+	nodeId, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("env %s: %w", key, err)
+	}
+	return NewSnowflakeGenerator(nodeId)
+}
+
+// 根据本机网卡IP生成节点ID，name为空时取第一个非回环网卡
+func NewSnowflakeGeneratorFromInterface(name string) (*SnowflakeGenerator, error) {
+	ip, err := GetInterfaceIP(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnowflakeGenerator(InetAtoN(ip) % 1024)
+}
+
+func (g *SnowflakeGenerator) Generate() string {
+	return g.node.Generate().String()
+}
+
+// UUID v4 生成器
+type UUIDGenerator struct{}
+
+func NewUUIDGenerator() *UUIDGenerator {
+	return &UUIDGenerator{}
+}
+
+func (g *UUIDGenerator) Generate() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+// ULID 生成器，同一毫秒内单调递增
+type ULIDGenerator struct {
+	lock    sync.Mutex
+	lastMs  uint64
+	lastRnd [10]byte
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{}
+}
+
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ULIDGenerator) Generate() string {
+	g.lock.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms == g.lastMs {
+		//同一毫秒内随机部分加一
+		for i := len(g.lastRnd) - 1; i >= 0; i-- {
+			g.lastRnd[i]++
+			if g.lastRnd[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(g.lastRnd[:]); err != nil {
+			g.lock.Unlock()
+			panic(err)
+		}
+		g.lastMs = ms
+	}
+	var b [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(b[0:6], ts[2:])
+	copy(b[6:], g.lastRnd[:])
+	g.lock.Unlock()
+
+	return encodeULID(b)
+}
+
+// Crockford base32 编码，128位编码为26个字符
+func encodeULID(b [16]byte) string {
+	var dst [26]byte
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	for i := 25; i >= 0; i-- {
+		dst[i] = ulidEncoding[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(dst[:])
+}
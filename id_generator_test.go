@@ -0,0 +1,50 @@
+package go_websocket
+
+import (
+	"sync"
+	"testing"
+)
+
+// 重置默认ID生成器，测试结束后恢复
+func resetDefaultIDGenerator(t *testing.T) {
+	defaultIDGeneratorLock.Lock()
+	g, err := defaultIDGenerator, defaultIDGeneratorErr
+	defaultIDGenerator, defaultIDGeneratorErr = nil, nil
+	defaultIDGeneratorOnce = sync.Once{}
+	defaultIDGeneratorLock.Unlock()
+	t.Cleanup(func() {
+		defaultIDGeneratorLock.Lock()
+		defaultIDGenerator, defaultIDGeneratorErr = g, err
+		if g == nil && err == nil {
+			defaultIDGeneratorOnce = sync.Once{}
+		}
+		defaultIDGeneratorLock.Unlock()
+	})
+}
+
+func TestDefaultIDGeneratorInvalidEnv(t *testing.T) {
+	for _, val := range []string{"abc", "1024", "-1"} {
+		t.Run(val, func(t *testing.T) {
+			resetDefaultIDGenerator(t)
+			t.Setenv(NodeIdEnv, val)
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s=%s did not panic", NodeIdEnv, val)
+				}
+			}()
+			NewClientManage()
+		})
+	}
+}
+
+func TestDefaultIDGeneratorEnv(t *testing.T) {
+	resetDefaultIDGenerator(t)
+	t.Setenv(NodeIdEnv, "7")
+	g, ok := DefaultIDGenerator().(*SnowflakeGenerator)
+	if !ok {
+		t.Fatal("not a snowflake generator")
+	}
+	if id := g.node.Generate().Node(); id != 7 {
+		t.Fatalf("node %d", id)
+	}
+}
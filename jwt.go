@@ -0,0 +1,351 @@
+package go_websocket
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken  = NewAuthError(http.StatusUnauthorized, "invalid token")
+	ErrKeyNotFound   = NewAuthError(http.StatusUnauthorized, "key not found")
+	ErrAlgNotAllowed = NewAuthError(http.StatusUnauthorized, "alg not allowed")
+)
+
+// JWT密钥集，kid => 密钥
+//
+// HS256/HS384/HS512 使用 []byte
+// RS256/RS384/RS512/PS256/PS384/PS512 使用 *rsa.PublicKey
+// ES256/ES384/ES512 使用 *ecdsa.PublicKey，曲线分别为 P-256、P-384、P-521
+// EdDSA 使用 ed25519.PublicKey
+// 令牌没有kid时使用 key 为 "" 的密钥
+type JWTKeySet map[string]interface{}
+
+// 解析本地JWKS
+func ParseJWKS(data []byte) (JWTKeySet, error) {
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	set := make(JWTKeySet)
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "oct":
+			key, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwks %s: %w", k.Kid, err)
+			}
+			set[k.Kid] = key
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("jwks %s: invalid rsa key", k.Kid)
+			}
+			set[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("jwks %s: unsupported curve %s", k.Kid, k.Crv)
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("jwks %s: invalid ec key", k.Kid)
+			}
+			set[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		case "OKP":
+			if k.Crv != "Ed25519" {
+				return nil, fmt.Errorf("jwks %s: unsupported curve %s", k.Kid, k.Crv)
+			}
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("jwks %s: invalid ed25519 key", k.Kid)
+			}
+			set[k.Kid] = ed25519.PublicKey(x)
+		default:
+			return nil, fmt.Errorf("jwks %s: unsupported kty %s", k.Kid, k.Kty)
+		}
+	}
+	return set, nil
+}
+
+// JWT鉴权
+type JWTAuthenticator struct {
+	KeySet     JWTKeySet                                              //密钥集
+	Algs       []string                                               //允许的算法，为空时允许密钥类型支持的全部算法
+	Issuer     string                                                 //校验iss，为空不校验
+	Audience   string                                                 //校验aud，为空不校验
+	Leeway     time.Duration                                          //exp、nbf允许的时间误差
+	TokenQuery string                                                 //浏览器无法设置请求头时，从该query参数读取令牌
+	ToIdentity func(claims map[string]interface{}) (*Identity, error) //声明转身份，为空时使用 sub、system_id、groups
+}
+
+func NewJWTAuthenticator(keySet JWTKeySet) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		KeySet:     keySet,
+		TokenQuery: "access_token",
+	}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := TokenFromRequest(r, a.TokenQuery)
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if a.ToIdentity != nil {
+		return a.ToIdentity(claims)
+	}
+	return identityFromClaims(claims), nil
+}
+
+// 校验令牌并返回声明
+func (a *JWTAuthenticator) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if len(a.Algs) > 0 && !containsString(a.Algs, header.Alg) {
+		return nil, ErrAlgNotAllowed
+	}
+
+	key, ok := a.KeySet[header.Kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// 校验标准声明
+func (a *JWTAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+			return ErrTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrInvalidToken
+		}
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return ErrInvalidToken
+		}
+	}
+	if a.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud != a.Audience {
+				return ErrInvalidToken
+			}
+		case []interface{}:
+			found := false
+			for _, v := range aud {
+				if s, _ := v.(string); s == a.Audience {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return ErrInvalidToken
+			}
+		default:
+			return ErrInvalidToken
+		}
+	}
+	return nil
+}
+
+// 校验签名，算法必须与密钥类型匹配
+func verifyJWTSignature(alg string, key interface{}, signed []byte, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "HS256", "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "HS384", "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "HS512", "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return ErrAlgNotAllowed
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			return ErrAlgNotAllowed
+		}
+		var mac = hmac.New(sha256.New, k)
+		switch hash {
+		case crypto.SHA384:
+			mac = hmac.New(sha512.New384, k)
+		case crypto.SHA512:
+			mac = hmac.New(sha512.New, k)
+		}
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSign
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := hashSum(hash, signed)
+		switch {
+		case strings.HasPrefix(alg, "RS"):
+			if rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+				return ErrInvalidSign
+			}
+		case strings.HasPrefix(alg, "PS"):
+			if rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) != nil {
+				return ErrInvalidSign
+			}
+		default:
+			return ErrAlgNotAllowed
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		//RFC 7518 3.4 算法和曲线一一对应
+		if curve, ok := esCurves[alg]; !ok || k.Curve == nil || k.Curve.Params().Name != curve {
+			return ErrAlgNotAllowed
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSign
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, hashSum(hash, signed), r, s) {
+			return ErrInvalidSign
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return ErrAlgNotAllowed
+		}
+		if !ed25519.Verify(k, signed, sig) {
+			return ErrInvalidSign
+		}
+		return nil
+	}
+	return ErrAlgNotAllowed
+}
+
+// ES 算法对应的曲线
+var esCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+func hashSum(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// 默认声明转身份
+func identityFromClaims(claims map[string]interface{}) *Identity {
+	identity := &Identity{Claims: claims}
+	identity.UserId, _ = claims["sub"].(string)
+	identity.SystemId, _ = claims["system_id"].(string)
+	switch groups := claims["groups"].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	case string:
+		if groups != "" {
+			identity.Groups = strings.Split(groups, ",")
+		}
+	}
+	return identity
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
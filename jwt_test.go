@@ -0,0 +1,168 @@
+package go_websocket
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成测试令牌，sign 为空时不签名
+func signJWT(t *testing.T, header, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	var sig []byte
+	if sign != nil {
+		sig = sign([]byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashSum(crypto.SHA256, signed))
+		return sig
+	}
+}
+
+// ES 签名，r、s 按曲线长度填充
+func es(key *ecdsa.PrivateKey, hash crypto.Hash) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, key, hashSum(hash, signed))
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	keys := JWTKeySet{
+		"hs":    secret,
+		"rsa":   &rsaKey.PublicKey,
+		"p256":  &p256.PublicKey,
+		"p384":  &p384.PublicKey,
+		"ed":    edPub,
+		"rsahs": rsaPub, //RSA 公钥内容被当作 HMAC 密钥
+	}
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "u1", "exp": now + 60}
+	header := func(alg, kid string) map[string]interface{} {
+		return map[string]interface{}{"alg": alg, "kid": kid}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		auth  func(a *JWTAuthenticator)
+		err   error
+	}{
+		{"hs256", signJWT(t, header("HS256", "hs"), valid, hs256(secret)), nil, nil},
+		{"rs256", signJWT(t, header("RS256", "rsa"), valid, rs256(rsaKey)), nil, nil},
+		{"es256", signJWT(t, header("ES256", "p256"), valid, es(p256, crypto.SHA256)), nil, nil},
+		{"es384", signJWT(t, header("ES384", "p384"), valid, es(p384, crypto.SHA384)), nil, nil},
+		{"eddsa", signJWT(t, header("EdDSA", "ed"), valid, func(s []byte) []byte { return ed25519.Sign(edKey, s) }), nil, nil},
+
+		{"none", signJWT(t, header("none", "hs"), valid, nil), nil, ErrAlgNotAllowed},
+		{"none uppercase", signJWT(t, header("NONE", "hs"), valid, nil), nil, ErrAlgNotAllowed},
+		{"empty alg", signJWT(t, header("", "hs"), valid, nil), nil, ErrAlgNotAllowed},
+		{"hs256 with rsa key", signJWT(t, header("HS256", "rsa"), valid, hs256(secret)), nil, ErrAlgNotAllowed},
+		{"rs256 with hmac key", signJWT(t, header("RS256", "hs"), valid, rs256(rsaKey)), nil, ErrAlgNotAllowed},
+		{"es256 with p384 key", signJWT(t, header("ES256", "p384"), valid, es(p384, crypto.SHA256)), nil, ErrAlgNotAllowed},
+		{"es384 with p256 key", signJWT(t, header("ES384", "p256"), valid, es(p256, crypto.SHA384)), nil, ErrAlgNotAllowed},
+		{"es256 with ed25519 key", signJWT(t, header("ES256", "ed"), valid, es(p256, crypto.SHA256)), nil, ErrAlgNotAllowed},
+		{"eddsa with ec key", signJWT(t, header("EdDSA", "p256"), valid, func(s []byte) []byte { return ed25519.Sign(edKey, s) }), nil, ErrAlgNotAllowed},
+		{"hs256 signed with public key", signJWT(t, header("HS256", "rsahs"), valid, hs256(rsaPub)), func(a *JWTAuthenticator) {
+			a.Algs = []string{"RS256"}
+		}, ErrAlgNotAllowed},
+		{"alg not in list", signJWT(t, header("HS256", "hs"), valid, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Algs = []string{"RS256"}
+		}, ErrAlgNotAllowed},
+		{"wrong secret", signJWT(t, header("HS256", "hs"), valid, hs256([]byte("other"))), nil, ErrInvalidSign},
+		{"unknown kid", signJWT(t, header("HS256", "x"), valid, hs256(secret)), nil, ErrKeyNotFound},
+		{"malformed", "a.b", nil, ErrInvalidToken},
+
+		{"expired", signJWT(t, header("HS256", "hs"), map[string]interface{}{"exp": now - 60}, hs256(secret)), nil, ErrTokenExpired},
+		{"expired within leeway", signJWT(t, header("HS256", "hs"), map[string]interface{}{"exp": now - 5}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Leeway = 30 * time.Second
+		}, nil},
+		{"not before", signJWT(t, header("HS256", "hs"), map[string]interface{}{"nbf": now + 60}, hs256(secret)), nil, ErrInvalidToken},
+		{"not before within leeway", signJWT(t, header("HS256", "hs"), map[string]interface{}{"nbf": now + 5}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Leeway = 30 * time.Second
+		}, nil},
+
+		{"aud", signJWT(t, header("HS256", "hs"), map[string]interface{}{"aud": "ws"}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Audience = "ws"
+		}, nil},
+		{"aud list", signJWT(t, header("HS256", "hs"), map[string]interface{}{"aud": []string{"api", "ws"}}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Audience = "ws"
+		}, nil},
+		{"aud mismatch", signJWT(t, header("HS256", "hs"), map[string]interface{}{"aud": []string{"api"}}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Audience = "ws"
+		}, ErrInvalidToken},
+		{"aud missing", signJWT(t, header("HS256", "hs"), valid, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Audience = "ws"
+		}, ErrInvalidToken},
+		{"iss mismatch", signJWT(t, header("HS256", "hs"), map[string]interface{}{"iss": "other"}, hs256(secret)), func(a *JWTAuthenticator) {
+			a.Issuer = "me"
+		}, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewJWTAuthenticator(keys)
+			if tt.auth != nil {
+				tt.auth(a)
+			}
+			_, err := a.Verify(tt.token)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifyTampered(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	a := NewJWTAuthenticator(JWTKeySet{"": secret})
+	token := signJWT(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "u1"}, hs256(secret))
+	if claims, err := a.Verify(token); err != nil || claims["sub"] != "u1" {
+		t.Fatalf("Verify: %v %v", claims, err)
+	}
+
+	//替换声明，沿用原签名
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "admin"})
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := a.Verify(strings.Join(parts, ".")); err != ErrInvalidSign {
+		t.Fatalf("tampered payload: %v", err)
+	}
+}
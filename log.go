@@ -127,6 +127,16 @@ func (l *Logger) JSONFormat(level LogLevel, msg string) map[string]interface{} {
 	data["time"] = time.Now().Local().Format("2006-01-02 15:04:05")
 	data["msg"] = msg
 	data["callers"] = l.callers
+	if info, ok := RequestInfoFromContext(l.ctx); ok {
+		if info.Id != "" {
+			data["request_id"] = info.Id
+		}
+		if info.Url != "" {
+			data["url"] = info.Url
+		}
+		data["client_id"] = info.ClientId
+		data["system_id"] = info.SystemId
+	}
 	if len(l.fields) > 0 {
 		for k, v := range l.fields {
 			if _, ok := data[k]; !ok {
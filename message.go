@@ -0,0 +1,55 @@
+package go_websocket
+
+import "github.com/gorilla/websocket"
+
+// 发送队列中的消息
+type outMessage struct {
+	msgType  int                        //消息类型
+	data     []byte                     //消息内容
+	prepared *websocket.PreparedMessage //预编码消息，不为空时忽略 data
+}
+
+func newOutMessage(msgType int, data []byte) *outMessage {
+	return &outMessage{
+		msgType: msgType,
+		data:    data,
+	}
+}
+
+// 群发时使用的预编码消息，帧和压缩只计算一次
+func newPreparedOutMessage(msgType int, data []byte) (*outMessage, error) {
+	pm, err := websocket.NewPreparedMessage(msgType, data)
+	if err != nil {
+		return nil, err
+	}
+	return &outMessage{
+		msgType:  msgType,
+		data:     data,
+		prepared: pm,
+	}, nil
+}
+
+// 写消息，达到压缩阈值的消息压缩发送并统计
+func (c *Client) writeMessage(m *outMessage) error {
+	compress := c.shouldCompress(len(m.data))
+	c.conn.EnableWriteCompression(compress)
+	if !compress {
+		return c.write(m)
+	}
+
+	before := c.wire.Written()
+	if err := c.write(m); err != nil {
+		return err
+	}
+	wire := c.wire.Written() - before
+	c.compression.add(len(m.data), wire)
+	c.clientManage.compression.add(len(m.data), wire)
+	return nil
+}
+
+func (c *Client) write(m *outMessage) error {
+	if m.prepared != nil {
+		return c.conn.WritePreparedMessage(m.prepared)
+	}
+	return c.conn.WriteMessage(m.msgType, m.data)
+}
@@ -0,0 +1,135 @@
+package go_websocket
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 捕获处理器panic，给客户端返回 ErrInternal
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, client *Client, params interface{}) (res IResponse, err error) {
+			defer func() {
+				if e := recover(); e != nil {
+					Log.WithFields(LogFields{
+						"stack": string(debug.Stack()),
+					}).Error(ctx, "Handler Panic ", e)
+					res = nil
+					err = ErrInternal
+				}
+			}()
+			return next(ctx, client, params)
+		}
+	}
+}
+
+// 访问日志，记录耗时和错误
+func AccessLog() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+			start := time.Now()
+			res, err := next(ctx, client, params)
+			fields := LogFields{
+				"latency": time.Since(start).String(),
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			Log.WithFields(fields).Info(ctx, "access")
+			return res, err
+		}
+	}
+}
+
+// 限流，每个客户端一个令牌桶，每秒生成 rate 个令牌，最多 burst 个
+//
+// 注册在路由上时为该路由单独限流，注册为全局中间件时所有路由共用
+func RateLimit(rate float64, burst int) Middleware {
+	limiter := newRateLimiter(rate, burst)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+			if !limiter.allow(client.GetID()) {
+				return nil, ErrRateLimited
+			}
+			return next(ctx, client, params)
+		}
+	}
+}
+
+// 要求客户端加入了全部指定的组
+func RequireGroups(groups ...string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+			for _, g := range groups {
+				if !client.InGroup(g) {
+					return nil, ErrForbidden
+				}
+			}
+			return next(ctx, client, params)
+		}
+	}
+}
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 清理已经装满的桶，避免客户端断开后残留
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package go_websocket
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 记录执行顺序的中间件
+func traceMiddleware(trace *[]string, name string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+			*trace = append(*trace, name)
+			return next(ctx, client, params)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var trace []string
+	h := NewClientHandler()
+	handler := func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		trace = append(trace, "handler")
+		return nil, nil
+	}
+	c := NewClient("1", "s", nil, NewClientManage())
+	dispatch := func(url string, want ...string) {
+		t.Helper()
+		trace = nil
+		if _, err := h.Dispatch(context.Background(), c, &ClientRequest{Url: url}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(trace, want) {
+			t.Fatalf("%s: got %v, want %v", url, trace, want)
+		}
+	}
+
+	h.Use(traceMiddleware(&trace, "global1"))
+	api := h.Group("/api", traceMiddleware(&trace, "api"))
+	v1 := api.Group("/v1", traceMiddleware(&trace, "v1"))
+	v1.Register("/x", handler, traceMiddleware(&trace, "route"))
+	dispatch("/api/v1/x", "global1", "api", "v1", "route", "handler")
+
+	//全局中间件对已注册的路由同样生效
+	h.Use(traceMiddleware(&trace, "global2"))
+	dispatch("/api/v1/x", "global1", "global2", "api", "v1", "route", "handler")
+
+	//路由组中间件只对之后注册的路由生效
+	api.Use(traceMiddleware(&trace, "late"))
+	api.Register("/y", handler)
+	dispatch("/api/y", "global1", "global2", "api", "late", "handler")
+	dispatch("/api/v1/x", "global1", "global2", "api", "v1", "route", "handler")
+}
+
+func TestRequireGroups(t *testing.T) {
+	h := NewClientHandler()
+	h.Register("/admin", func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		return NewOkClientRes("ok"), nil
+	}, RequireGroups("admin", "staff"))
+	c := NewClient("1", "s", nil, NewClientManage())
+
+	c.AddGroup("admin")
+	if _, err := h.Dispatch(context.Background(), c, &ClientRequest{Url: "/admin"}); err != ErrForbidden {
+		t.Fatalf("one group: %v", err)
+	}
+	c.AddGroup("staff")
+	if _, err := h.Dispatch(context.Background(), c, &ClientRequest{Url: "/admin"}); err != nil {
+		t.Fatalf("all groups: %v", err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	h := NewClientHandler()
+	h.Use(Recovery())
+	h.Register("/panic", func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		panic("boom")
+	})
+	c := NewClient("1", "s", nil, NewClientManage())
+	if res, err := h.Dispatch(context.Background(), c, &ClientRequest{Url: "/panic"}); res != nil || err != ErrInternal {
+		t.Fatalf("got %v %v", res, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h := NewClientHandler()
+	h.Register("/x", func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		return nil, nil
+	}, RateLimit(1, 2))
+	h.Register("/y", func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		return nil, nil
+	})
+	cm := NewClientManage()
+	c1, c2 := NewClient("1", "s", nil, cm), NewClient("2", "s", nil, cm)
+	call := func(c *Client, url string) error {
+		_, err := h.Dispatch(context.Background(), c, &ClientRequest{Url: url})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := call(c1, "/x"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := call(c1, "/x"); err != ErrRateLimited {
+		t.Fatalf("over burst: %v", err)
+	}
+	//每个客户端单独限流，未注册限流的路由不受影响
+	if err := call(c2, "/x"); err != nil {
+		t.Fatalf("other client: %v", err)
+	}
+	if err := call(c1, "/y"); err != nil {
+		t.Fatalf("other route: %v", err)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newRateLimiter(10, 2)
+	for i := 0; i < 2; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d limited", i)
+		}
+	}
+	if l.allow("a") {
+		t.Fatal("over burst allowed")
+	}
+
+	//150ms 生成 1.5 个令牌
+	l.buckets["a"].last = l.buckets["a"].last.Add(-150 * time.Millisecond)
+	if !l.allow("a") {
+		t.Fatal("refilled token limited")
+	}
+	if l.allow("a") {
+		t.Fatal("partial token allowed")
+	}
+
+	//令牌不超过 burst
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d limited", i)
+		}
+	}
+	if l.allow("a") {
+		t.Fatal("over burst allowed")
+	}
+
+	//清理已装满的桶
+	l.allow("b")
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	l.lastSweep = l.lastSweep.Add(-2 * time.Minute)
+	l.allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("full bucket not swept")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("used bucket swept")
+	}
+}
@@ -0,0 +1,313 @@
+package go_websocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 离线消息键前缀
+const (
+	OfflineKeyUser = "user:" //按用户保存
+)
+
+// 离线消息
+type StoredMessage struct {
+	Data      []byte    `json:"data"`
+	Binary    bool      `json:"binary,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpireAt  time.Time `json:"expire_at,omitempty"` //为零值时不过期
+}
+
+// 是否已过期
+func (m StoredMessage) Expired(now time.Time) bool {
+	return !m.ExpireAt.IsZero() && now.After(m.ExpireAt)
+}
+
+// 每个键的离线消息限制
+type StoreLimits struct {
+	MaxMessages int           //最多保存的消息数，超出时丢弃最旧的，小于等于0不限制
+	TTL         time.Duration //保存时间，小于等于0不过期
+}
+
+// 按键返回离线消息限制
+type StoreLimitsFunc func(key string) StoreLimits
+
+// 离线消息存储，同一个键的消息按保存顺序取出
+type MessageStore interface {
+	//保存消息，按 limits 丢弃最旧的消息
+	Append(ctx context.Context, key string, msg StoredMessage, limits StoreLimits) error
+	//按顺序取出并删除未过期的消息
+	Take(ctx context.Context, key string) ([]StoredMessage, error)
+	//关闭
+	Close() error
+}
+
+// 用户离线消息键
+func UserOfflineKey(userId string) string {
+	return OfflineKeyUser + userId
+}
+
+// 给用户发消息，用户的所有客户端都会收到，同时在多个目标的客户端只发送一次
+//
+// 配置了离线消息存储时，不在线的用户保存离线消息，重连后按顺序补发
+func (cm *ClientManage) SendUserMsg(msg []byte, userIds ...string) *DeliveryReport {
+	return cm.Send(msg, Target{Users: userIds})
+}
+
+// 键的离线消息限制
+func (cm *ClientManage) offlineLimits(key string) StoreLimits {
+	if fn := cm.opts.OfflineLimitsFn; fn != nil {
+		return fn(key)
+	}
+	return cm.opts.OfflineLimits
+}
+
+// 保存离线消息
+//
+// 未配置消息总线时保存不在本节点的用户；配置了消息总线时，
+// 只有在线状态存储确认集群内不在线的才保存，其余由其他节点投递
+//
+// 发给客户端ID的消息不保存，连接断开等待恢复的客户端仍在注册表中，消息由会话缓存
+func (cm *ClientManage) storeOffline(data []byte, binary bool, target Target, report *DeliveryReport) {
+	store := cm.opts.MessageStore
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cm.opts.WriteDeadline)
+	defer cancel()
+
+	keys := make([]string, 0)
+	for _, uid := range report.offlineUsers {
+		if cm.opts.Broker != nil && !cm.userOffline(ctx, uid) {
+			continue
+		}
+		keys = append(keys, UserOfflineKey(uid))
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		limits := cm.offlineLimits(key)
+		msg := StoredMessage{
+			Data:      data,
+			Binary:    binary,
+			CreatedAt: now,
+		}
+		if limits.TTL > 0 {
+			msg.ExpireAt = now.Add(limits.TTL)
+		}
+		if err := store.Append(ctx, key, msg, limits); err != nil {
+			Log.Error(ctx, "MessageStore Append Error ", err)
+			continue
+		}
+		report.Stored = append(report.Stored, key)
+	}
+}
+
+// 用户是否在集群内不在线，未配置在线状态存储或查询失败时视为在线
+func (cm *ClientManage) userOffline(ctx context.Context, userId string) bool {
+	if cm.opts.Presence == nil {
+		return false
+	}
+	list, err := cm.GetClusterUserClients(ctx, userId)
+	return err == nil && len(list) <= 0
+}
+
+// 补发用户的离线消息，发送队列满或客户端断开时剩余的消息重新保存
+//
+// 需在注册前调用 holdLiveMsg，补发完成后再发送期间暂存的实时消息
+func (cm *ClientManage) replayOffline(c *Client) {
+	store := cm.opts.MessageStore
+	if store == nil {
+		return
+	}
+	defer c.releaseLive()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cm.opts.WriteDeadline)
+	defer cancel()
+
+	uid := c.GetUserId()
+	if len(uid) <= 0 {
+		return
+	}
+	key := UserOfflineKey(uid)
+	list, err := store.Take(ctx, key)
+	if err != nil {
+		Log.Error(c.ctx, "MessageStore Take Error ", err)
+		return
+	}
+	for i, m := range list {
+		if err = c.replayMsg(m); err == nil {
+			continue
+		}
+
+		Log.Error(c.ctx, "Replay Offline Error ", err)
+		//格式化失败的消息丢弃，不影响后续消息
+		if dropReason(err) == DropReasonError {
+			continue
+		}
+		limits := cm.offlineLimits(key)
+		for _, rest := range list[i:] {
+			if err := store.Append(ctx, key, rest, limits); err != nil {
+				Log.Error(c.ctx, "MessageStore Append Error ", err)
+			}
+		}
+		return
+	}
+}
+
+// 补发一条离线消息，不经过暂存
+func (c *Client) replayMsg(m StoredMessage) (err error) {
+	if c.IsClosed() {
+		return ErrClientClosed
+	}
+	if m.Binary {
+		return c.enqueue(newOutMessage(websocket.BinaryMessage, m.Data))
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			Log.Error(c.ctx, "ReplayMsg Panic ", e)
+			err = fmt.Errorf("replay panic: %v", e)
+		}
+	}()
+	res, err := c.responseFormatFunc()(c, m.Data)
+	if err != nil {
+		return err
+	}
+	if p, ok := res.(IPushResponse); ok {
+		p.SetPush()
+	}
+	return c.sendResponse(res)
+}
+
+// 补发离线消息期间暂存的实时消息
+type replayGate struct {
+	holding bool
+	pending []func() error
+	lock    sync.Mutex
+}
+
+// 开始暂存实时消息，需在客户端注册前调用
+func (c *Client) holdLiveMsg() {
+	c.replay.lock.Lock()
+	defer c.replay.lock.Unlock()
+	c.replay.holding = true
+}
+
+// 暂存中时暂存发送，返回 true 表示已暂存，超过发送队列长度时返回 ErrQueueFull
+func (c *Client) holdLive(fn func() error) (bool, error) {
+	g := &c.replay
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.holding {
+		return false, nil
+	}
+	if len(g.pending) >= cap(c.send) {
+		return true, ErrQueueFull
+	}
+	g.pending = append(g.pending, fn)
+	return true, nil
+}
+
+// 发送实时消息，补发中时暂存
+func (c *Client) enqueueLive(m *outMessage) error {
+	if held, err := c.holdLive(func() error { return c.enqueue(m) }); held {
+		return err
+	}
+	return c.enqueue(m)
+}
+
+// 补发完成，按顺序发送暂存的消息，发送完前新的实时消息等待
+func (c *Client) releaseLive() {
+	g := &c.replay
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, fn := range g.pending {
+		if err := fn(); err != nil {
+			Log.Error(c.ctx, "Send Held Message Error ", err)
+		}
+	}
+	g.pending = nil
+	g.holding = false
+}
+
+// 内存离线消息存储，定时清理所有键的过期消息
+type MemoryMessageStore struct {
+	messages  map[string][]StoredMessage
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// 存储清理所有键的过期消息的间隔
+const offlineSweepInterval = time.Minute
+
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		messages: make(map[string][]StoredMessage),
+	}
+}
+
+func (s *MemoryMessageStore) Append(ctx context.Context, key string, msg StoredMessage, limits StoreLimits) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= offlineSweepInterval {
+		s.sweep(now)
+	}
+	list := make([]StoredMessage, 0, len(s.messages[key])+1)
+	for _, m := range s.messages[key] {
+		if !m.Expired(now) {
+			list = append(list, m)
+		}
+	}
+	list = append(list, msg)
+	if limits.MaxMessages > 0 && len(list) > limits.MaxMessages {
+		list = list[len(list)-limits.MaxMessages:]
+	}
+	s.messages[key] = list
+	return nil
+}
+
+func (s *MemoryMessageStore) Take(ctx context.Context, key string) ([]StoredMessage, error) {
+	s.lock.Lock()
+	list := s.messages[key]
+	delete(s.messages, key)
+	s.lock.Unlock()
+
+	now := time.Now()
+	res := make([]StoredMessage, 0, len(list))
+	for _, m := range list {
+		if !m.Expired(now) {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// 清理所有键的过期消息，需持有锁
+func (s *MemoryMessageStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, list := range s.messages {
+		rest := list[:0]
+		for _, m := range list {
+			if !m.Expired(now) {
+				rest = append(rest, m)
+			}
+		}
+		if len(rest) <= 0 {
+			delete(s.messages, key)
+		} else {
+			s.messages[key] = rest
+		}
+	}
+}
+
+func (s *MemoryMessageStore) Close() error {
+	return nil
+}
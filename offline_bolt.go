@@ -0,0 +1,168 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDB 离线消息存储，每个键一个 bucket，消息按自增序号保存
+//
+// 打开时和之后每分钟清理所有键的过期消息
+type BoltMessageStore struct {
+	db        *bolt.DB
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// 打开或创建数据库文件
+func NewBoltMessageStore(path string) (*BoltMessageStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltMessageStore{db: db, lastSweep: time.Now()}
+	if err := s.sweep(s.lastSweep); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltMessageStore) Append(ctx context.Context, key string, msg StoredMessage, limits StoreLimits) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	sweep := now.Sub(s.lastSweep) >= offlineSweepInterval
+	if sweep {
+		s.lastSweep = now
+	}
+	s.lock.Unlock()
+	if sweep {
+		if err := s.sweep(now); err != nil {
+			Log.Error(ctx, "BoltMessageStore Sweep Error ", err)
+		}
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		id := make([]byte, 8)
+		binary.BigEndian.PutUint64(id, seq)
+		if err := b.Put(id, data); err != nil {
+			return err
+		}
+
+		//删除过期的消息，超出数量时从最旧的开始删除
+		keep, err := boltExpire(b, now)
+		if err != nil {
+			return err
+		}
+		if limits.MaxMessages > 0 && len(keep) > limits.MaxMessages {
+			for _, k := range keep[:len(keep)-limits.MaxMessages] {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// 删除 bucket 中过期和无法解析的消息，返回保留的消息序号
+func boltExpire(b *bolt.Bucket, now time.Time) ([][]byte, error) {
+	keep := make([][]byte, 0)
+	remove := make([][]byte, 0)
+	if err := b.ForEach(func(k, v []byte) error {
+		k = append([]byte(nil), k...)
+		m := StoredMessage{}
+		if err := json.Unmarshal(v, &m); err != nil || m.Expired(now) {
+			remove = append(remove, k)
+		} else {
+			keep = append(keep, k)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, k := range remove {
+		if err := b.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return keep, nil
+}
+
+// 清理所有键的过期消息，删除空的 bucket
+func (s *BoltMessageStore) sweep(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		names := make([][]byte, 0)
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			keep, err := boltExpire(tx.Bucket(name), now)
+			if err != nil {
+				return err
+			}
+			if len(keep) <= 0 {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltMessageStore) Take(ctx context.Context, key string) ([]StoredMessage, error) {
+	list := make([]StoredMessage, 0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+
+		now := time.Now()
+		if err := b.ForEach(func(k, v []byte) error {
+			//无法解析的消息跳过，与保存时一致
+			m := StoredMessage{}
+			if err := json.Unmarshal(v, &m); err != nil {
+				Log.Error(ctx, "BoltMessageStore Decode Error ", err)
+				return nil
+			}
+			if !m.Expired(now) {
+				list = append(list, m)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *BoltMessageStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,157 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMemoryMessageStoreSweep(t *testing.T) {
+	s := NewMemoryMessageStore()
+	ctx := context.Background()
+	now := time.Now()
+	expired := StoredMessage{Data: []byte("x"), CreatedAt: now, ExpireAt: now.Add(-time.Second)}
+	for _, key := range []string{"user:1", "user:2", "user:3"} {
+		s.Append(ctx, key, expired, StoreLimits{MaxMessages: 100})
+	}
+	s.Append(ctx, "user:4", StoredMessage{Data: []byte("y"), CreatedAt: now}, StoreLimits{MaxMessages: 100})
+
+	s.sweep(now)
+	if len(s.messages) != 1 {
+		t.Fatalf("keys after sweep: %d", len(s.messages))
+	}
+	list, _ := s.Take(ctx, "user:4")
+	if len(list) != 1 || string(list[0].Data) != "y" {
+		t.Fatalf("Take: %+v", list)
+	}
+}
+
+func TestStoreOfflineUsersOnly(t *testing.T) {
+	msg, _ := NewOkClientRes("x").GetBytes()
+	cm := NewClientManage(WithMessageStore(NewMemoryMessageStore(), StoreLimits{MaxMessages: 10}), WithSessionResume(time.Minute, 16))
+	if report := cm.SendClientMsg(msg, "gone"); len(report.Stored) > 0 {
+		t.Fatalf("client message stored %v", report.Stored)
+	}
+	if report := cm.SendUserMsg(msg, "u1"); len(report.Stored) != 1 || report.Stored[0] != UserOfflineKey("u1") {
+		t.Fatalf("user message stored %v", report.Stored)
+	}
+}
+
+func TestReplayBeforeLiveMessages(t *testing.T) {
+	cm := NewClientManage()
+	c := NewClient("1", "s", nil, cm)
+	live, _ := NewOkClientRes("live").GetBytes()
+	stored, _ := NewOkClientRes("stored").GetBytes()
+
+	c.holdLiveMsg()
+	if err := c.SendMsg(live); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.replayMsg(StoredMessage{Data: stored}); err != nil {
+		t.Fatal(err)
+	}
+	c.releaseLive()
+
+	for _, want := range []string{"stored", "live"} {
+		m := <-c.send
+		var res ClientResponse
+		if err := json.Unmarshal(m.data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Data != want {
+			t.Fatalf("got %v, want %s", res.Data, want)
+		}
+	}
+}
+
+func openBoltStore(t *testing.T, path string) *BoltMessageStore {
+	t.Helper()
+	s, err := NewBoltMessageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBoltMessageStore(t *testing.T) {
+	s := openBoltStore(t, filepath.Join(t.TempDir(), "offline.db"))
+	defer s.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		msg := StoredMessage{Data: []byte(fmt.Sprint(i)), CreatedAt: now}
+		if err := s.Append(ctx, "user:1", msg, StoreLimits{MaxMessages: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Append(ctx, "user:1", StoredMessage{Data: []byte("x"), ExpireAt: now.Add(-time.Second)}, StoreLimits{})
+
+	list, err := s.Take(ctx, "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || string(list[0].Data) != "2" || string(list[2].Data) != "4" {
+		t.Fatalf("Take: %+v", list)
+	}
+	if list, _ := s.Take(ctx, "user:1"); len(list) != 0 {
+		t.Fatalf("Take twice: %+v", list)
+	}
+}
+
+func TestBoltMessageStoreSweep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.db")
+	s := openBoltStore(t, path)
+	ctx := context.Background()
+	now := time.Now()
+	expired := StoredMessage{Data: []byte("x"), CreatedAt: now, ExpireAt: now.Add(50 * time.Millisecond)}
+	for _, key := range []string{"user:1", "user:2"} {
+		s.Append(ctx, key, expired, StoreLimits{})
+	}
+	s.Append(ctx, "user:3", StoredMessage{Data: []byte("y"), CreatedAt: now}, StoreLimits{})
+
+	//无法解析的消息跳过
+	s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("user:3")).Put([]byte{0xff}, []byte("bad"))
+	})
+	s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	//打开时清理过期的键
+	s = openBoltStore(t, path)
+	defer s.Close()
+	var buckets []string
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, string(name))
+			return nil
+		})
+	})
+	if len(buckets) != 1 || buckets[0] != "user:3" {
+		t.Fatalf("buckets after sweep: %v", buckets)
+	}
+	list, err := s.Take(ctx, "user:3")
+	if err != nil || len(list) != 1 || string(list[0].Data) != "y" {
+		t.Fatalf("Take: %+v %v", list, err)
+	}
+}
+
+func TestBoltMessageStoreTakeSkipsBadRecords(t *testing.T) {
+	s := openBoltStore(t, filepath.Join(t.TempDir(), "offline.db"))
+	defer s.Close()
+	ctx := context.Background()
+	s.Append(ctx, "user:1", StoredMessage{Data: []byte("a")}, StoreLimits{})
+	s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("user:1")).Put([]byte{0xff}, []byte("bad"))
+	})
+
+	list, err := s.Take(ctx, "user:1")
+	if err != nil || len(list) != 1 || string(list[0].Data) != "a" {
+		t.Fatalf("Take: %+v %v", list, err)
+	}
+}
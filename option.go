@@ -0,0 +1,404 @@
+package go_websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+// 管理器配置
+type Options struct {
+	ReadLimit         int64             //读取消息最大字节数
+	ReadDeadline      time.Duration     //读超时
+	HeartbeatInterval time.Duration     //心跳间隔
+	WriteDeadline     time.Duration     //写超时
+	PingMessage       string            //心跳内容
+	SendBufferSize    int               //发送通道大小
+	UpgraderConfig    UpgraderConfig    //升级器配置
+	Upgrader          *Upgrader         //升级器，不为空时忽略 UpgraderConfig
+	IDGenerator       IDGenerator       //客户端ID生成器，为空时使用默认生成器
+	Authenticator     Authenticator     //鉴权，为空时不鉴权
+	ErrorResponseFn   ErrorResponseFunc //错误响应格式化方法
+	ClientHandler     *ClientHandler    //路由表，为空时使用全局 WsClientHandler
+	Validator         Validator         //带类型处理方法的参数校验，为空时不校验
+	Concurrency       int               //每个客户端同时处理的请求数，小于等于1时在读循环中顺序处理
+	QueueSize         int               //每个客户端待处理请求队列大小
+	QueueFullPolicy   QueueFullPolicy   //待处理请求队列满时的策略
+	CloseCode         int               //关闭时发送的关闭码
+	CloseReason       string            //关闭时发送的原因
+	CloseTimeout      time.Duration     //发送关闭帧后等待对方回复的时间
+	BinaryEnvelope    BinaryEnvelope    //二进制信封，用于二进制帧的路由
+	Codecs            []Codec           //可通过查询参数选择的编解码器
+	CodecQuery        string            //选择编解码器的查询参数名
+
+	CompressionLevel     int //压缩级别，-2到9，对应 compress/flate
+	CompressionThreshold int //小于该字节数的消息不压缩
+
+	Broker Broker //消息总线，不为空时发送会转发给其他节点
+	NodeId string //节点ID，为空时使用 DefaultNodeId
+
+	Presence         PresenceStore //在线状态存储，不为空时记录集群内的客户端
+	PresenceInterval time.Duration //节点心跳间隔，需小于存储的 TTL
+
+	MessageStore    MessageStore    //离线消息存储，为空时不保存离线消息
+	OfflineLimits   StoreLimits     //每个键的离线消息限制
+	OfflineLimitsFn StoreLimitsFunc //按键返回离线消息限制，不为空时忽略 OfflineLimits
+
+	ResumeWindow     time.Duration //断开后保留会话的时间，小于等于0时不开启会话恢复
+	ResumeBufferSize int           //每个会话缓存的已发送消息数
+
+	AckRoute    string      //可靠发送的确认路由，为空时不开启可靠发送
+	RetryPolicy RetryPolicy //可靠发送的重传策略
+
+	Hooks         Hooks //生命周期回调
+	HookQueueSize int   //每个客户端等待执行的 OnMessage、OnError 回调数，超出时丢弃
+
+	SlowConsumerPolicy    SlowConsumerPolicy //发送队列满时的策略
+	SlowConsumerTimeout   time.Duration      //阻塞策略的等待时间
+	SlowConsumerCloseCode int                //断开策略的关闭码，1008或1013
+	OnSlowConsumer        SlowConsumerFunc   //发送队列满时的回调
+}
+
+type Option func(o *Options)
+
+// 默认配置
+func DefaultOptions() Options {
+	return Options{
+		ReadLimit:         ReadLimit,
+		ReadDeadline:      ReadDeadline,
+		HeartbeatInterval: HeartbeatInterval,
+		WriteDeadline:     WriteDeadline,
+		PingMessage:       PingMessage,
+		SendBufferSize:    SendBufferSize,
+		UpgraderConfig:    DefaultUpgraderConfig(),
+		ErrorResponseFn:   DefaultErrorResponseFunc,
+		Validator:         NewTagValidator(),
+		QueueSize:         64,
+		CloseCode:         CloseCode,
+		CloseReason:       CloseReason,
+		CloseTimeout:      CloseTimeout,
+		BinaryEnvelope:    LengthPrefixEnvelope{},
+		Codecs:            DefaultCodecs(),
+		CodecQuery:        "codec",
+
+		CompressionLevel:     CompressionLevel,
+		CompressionThreshold: CompressionThreshold,
+
+		OfflineLimits: StoreLimits{
+			MaxMessages: 100,
+			TTL:         24 * time.Hour,
+		},
+
+		ResumeBufferSize: ResumeBufferSize,
+
+		RetryPolicy: DefaultRetryPolicy(),
+
+		HookQueueSize: HookQueueSize,
+
+		SlowConsumerPolicy:    SlowConsumerDropNewest,
+		SlowConsumerTimeout:   time.Second,
+		SlowConsumerCloseCode: websocket.CloseTryAgainLater,
+	}
+}
+
+// 读取消息最大字节数
+func WithReadLimit(limit int64) Option {
+	return func(o *Options) {
+		o.ReadLimit = limit
+	}
+}
+
+// 读超时，需大于心跳间隔
+func WithReadDeadline(d time.Duration) Option {
+	return func(o *Options) {
+		o.ReadDeadline = d
+	}
+}
+
+// 心跳间隔
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.HeartbeatInterval = d
+	}
+}
+
+// 写超时
+func WithWriteDeadline(d time.Duration) Option {
+	return func(o *Options) {
+		o.WriteDeadline = d
+	}
+}
+
+// 心跳内容
+func WithPingMessage(msg string) Option {
+	return func(o *Options) {
+		o.PingMessage = msg
+	}
+}
+
+// 发送通道大小
+func WithSendBufferSize(size int) Option {
+	return func(o *Options) {
+		o.SendBufferSize = size
+	}
+}
+
+// 读缓冲区大小
+func WithReadBufferSize(size int) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.ReadBufferSize = size
+	}
+}
+
+// 写缓冲区大小
+func WithWriteBufferSize(size int) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.WriteBufferSize = size
+	}
+}
+
+// 握手超时
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.HandshakeTimeout = d
+	}
+}
+
+// 指定升级器
+func WithUpgrader(u *Upgrader) Option {
+	return func(o *Options) {
+		o.Upgrader = u
+	}
+}
+
+// 客户端ID生成器
+func WithIDGenerator(g IDGenerator) Option {
+	return func(o *Options) {
+		o.IDGenerator = g
+	}
+}
+
+// 鉴权
+func WithAuthenticator(a Authenticator) Option {
+	return func(o *Options) {
+		o.Authenticator = a
+	}
+}
+
+// 允许的Origin，支持 "*.example.com" 通配子域名，不配置时只允许同源
+func WithAllowedOrigins(origins ...string) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.AllowedOrigins = append(o.UpgraderConfig.AllowedOrigins, origins...)
+	}
+}
+
+// 自定义Origin检查
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.CheckOrigin = fn
+	}
+}
+
+// 支持的子协议，按顺序优先
+func WithSubprotocols(subprotocols ...Subprotocol) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.Subprotocols = append(o.UpgraderConfig.Subprotocols, subprotocols...)
+	}
+}
+
+// 错误响应格式化方法
+func WithErrorResponseFunc(fn ErrorResponseFunc) Option {
+	return func(o *Options) {
+		o.ErrorResponseFn = fn
+	}
+}
+
+// 路由表，每个管理器可以使用独立的路由
+func WithClientHandler(h *ClientHandler) Option {
+	return func(o *Options) {
+		o.ClientHandler = h
+	}
+}
+
+// 参数校验
+func WithValidator(v Validator) Option {
+	return func(o *Options) {
+		o.Validator = v
+	}
+}
+
+// 每个客户端同时处理的请求数和待处理请求队列大小
+func WithConcurrency(concurrency int, queueSize int) Option {
+	return func(o *Options) {
+		o.Concurrency = concurrency
+		o.QueueSize = queueSize
+	}
+}
+
+// 待处理请求队列满时的策略
+func WithQueueFullPolicy(policy QueueFullPolicy) Option {
+	return func(o *Options) {
+		o.QueueFullPolicy = policy
+	}
+}
+
+// 关闭时发送的关闭码和原因
+func WithCloseCode(code int, reason string) Option {
+	return func(o *Options) {
+		o.CloseCode = code
+		o.CloseReason = reason
+	}
+}
+
+// 发送关闭帧后等待对方回复的时间
+func WithCloseTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.CloseTimeout = d
+	}
+}
+
+// 发送队列满时的策略，timeout 为阻塞策略的等待时间
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.SlowConsumerPolicy = policy
+		o.SlowConsumerTimeout = timeout
+	}
+}
+
+// 断开策略的关闭码，1008（policy violation）或1013（try again later）
+func WithSlowConsumerCloseCode(code int) Option {
+	return func(o *Options) {
+		o.SlowConsumerCloseCode = code
+	}
+}
+
+// 发送队列满时的回调，可用于日志、告警
+func WithOnSlowConsumer(fn SlowConsumerFunc) Option {
+	return func(o *Options) {
+		o.OnSlowConsumer = fn
+	}
+}
+
+// 二进制信封，为空时使用默认的 LengthPrefixEnvelope
+func WithBinaryEnvelope(e BinaryEnvelope) Option {
+	return func(o *Options) {
+		if e == nil {
+			e = LengthPrefixEnvelope{}
+		}
+		o.BinaryEnvelope = e
+	}
+}
+
+// 可通过查询参数选择的编解码器，同名时后加入的生效
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *Options) {
+		list := make([]Codec, 0, len(o.Codecs)+len(codecs))
+		for _, c := range append(codecs, o.Codecs...) {
+			if _, ok := findCodec(list, c.Name()); !ok {
+				list = append(list, c)
+			}
+		}
+		o.Codecs = list
+	}
+}
+
+// 选择编解码器的查询参数名，为空时不能通过查询参数选择
+func WithCodecQuery(query string) Option {
+	return func(o *Options) {
+		o.CodecQuery = query
+	}
+}
+
+// 开启 permessage-deflate 压缩，小于 threshold 字节的消息不压缩
+//
+// level 为 compress/flate 的压缩级别，-2（只做哈夫曼编码）到9（最佳压缩）
+func WithCompression(level int, threshold int) Option {
+	return func(o *Options) {
+		o.UpgraderConfig.EnableCompression = true
+		o.CompressionLevel = level
+		o.CompressionThreshold = threshold
+	}
+}
+
+// 消息总线，集群部署时用于节点间转发消息
+func WithBroker(broker Broker) Option {
+	return func(o *Options) {
+		o.Broker = broker
+	}
+}
+
+// 节点ID，集群内唯一
+func WithNodeId(id string) Option {
+	return func(o *Options) {
+		o.NodeId = id
+	}
+}
+
+// 在线状态存储，interval 为节点心跳间隔，需小于存储的 TTL，小于等于0时为10秒
+func WithPresence(store PresenceStore, interval time.Duration) Option {
+	return func(o *Options) {
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		o.Presence = store
+		o.PresenceInterval = interval
+	}
+}
+
+// 离线消息存储，不在线的用户、客户端的消息按 limits 保存，重连后补发
+func WithMessageStore(store MessageStore, limits StoreLimits) Option {
+	return func(o *Options) {
+		o.MessageStore = store
+		o.OfflineLimits = limits
+	}
+}
+
+// 按键设置离线消息限制，键为 UserOfflineKey 的返回值
+func WithOfflineLimitsFunc(fn StoreLimitsFunc) Option {
+	return func(o *Options) {
+		o.OfflineLimitsFn = fn
+	}
+}
+
+// 开启会话恢复，断开后 window 内带上会话令牌和最后收到的序号重连可恢复会话并补发消息
+//
+// bufferSize 为每个会话缓存的已发送消息数，小于等于0时使用默认值
+func WithSessionResume(window time.Duration, bufferSize int) Option {
+	return func(o *Options) {
+		if bufferSize <= 0 {
+			bufferSize = ResumeBufferSize
+		}
+		o.ResumeWindow = window
+		o.ResumeBufferSize = bufferSize
+	}
+}
+
+// 开启可靠发送并设置确认路由，客户端发送该路由的请求确认消息，不会进入路由表
+//
+// 该路由不能再用作应用路由，可使用 AckRoute 常量
+func WithAckRoute(route string) Option {
+	return func(o *Options) {
+		o.AckRoute = route
+	}
+}
+
+// 可靠发送的重传策略
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *Options) {
+		o.RetryPolicy = policy
+	}
+}
+
+// 生命周期回调，同一客户端的回调按顺序执行
+func WithHooks(hooks Hooks) Option {
+	return func(o *Options) {
+		o.Hooks = hooks
+	}
+}
+
+// 每个客户端等待执行的 OnMessage、OnError 回调数，超出时丢弃，小于等于0不限制
+func WithHookQueueSize(size int) Option {
+	return func(o *Options) {
+		o.HookQueueSize = size
+	}
+}
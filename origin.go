@@ -0,0 +1,76 @@
+package go_websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 根据白名单检查Origin
+//
+// 支持的写法：
+// "*" 允许全部
+// "example.com" 匹配主机名（忽略端口），"example.com:8080" 匹配主机和端口
+// "*.example.com" 匹配所有子域名，不包括 example.com 本身
+// "https://example.com"、"https://*.example.com" 同时匹配协议
+// 没有Origin头的请求（非浏览器客户端）直接放行
+func NewOriginChecker(allowed ...string) func(r *http.Request) bool {
+	patterns := make([]string, 0, len(allowed))
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a != "" {
+			patterns = append(patterns, a)
+		}
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(strings.ToLower(origin))
+		if err != nil || u.Host == "" {
+			return false
+		}
+		for _, p := range patterns {
+			if matchOrigin(p, u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// 同源检查，Origin的主机必须与请求的Host一致
+func CheckSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func matchOrigin(pattern string, u *url.URL) bool {
+	if pattern == "*" {
+		return true
+	}
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if pattern[:i] != u.Scheme {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+
+	host := u.Host
+	if !strings.Contains(pattern, ":") {
+		host = u.Hostname()
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
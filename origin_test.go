@@ -0,0 +1,103 @@
+package go_websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginChecker(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{[]string{"example.com"}, "", true},
+		{[]string{"example.com"}, "https://example.com", true},
+		{[]string{"example.com"}, "http://example.com:8080", true},
+		{[]string{"example.com"}, "https://EXAMPLE.com", true},
+		{[]string{"Example.COM"}, "https://example.com", true},
+		{[]string{"example.com"}, "https://evilexample.com", false},
+		{[]string{"example.com"}, "https://example.com.evil.com", false},
+		{[]string{"example.com"}, "https://a.example.com", false},
+		{[]string{"example.com"}, "null", false},
+
+		{[]string{"*.example.com"}, "https://a.example.com", true},
+		{[]string{"*.example.com"}, "https://a.b.example.com", true},
+		{[]string{"*.example.com"}, "https://A.Example.com:443", true},
+		{[]string{"*.example.com"}, "https://example.com", false},
+		{[]string{"*.example.com"}, "https://evilexample.com", false},
+		{[]string{"*.example.com"}, "https://a.example.com.evil.com", false},
+
+		{[]string{"example.com:8080"}, "http://example.com:8080", true},
+		{[]string{"example.com:8080"}, "http://example.com:9090", false},
+		{[]string{"example.com:8080"}, "http://example.com", false},
+
+		{[]string{"https://example.com"}, "https://example.com", true},
+		{[]string{"https://example.com"}, "http://example.com", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com", true},
+		{[]string{"https://*.example.com"}, "http://a.example.com", false},
+		{[]string{"https://*.example.com"}, "https://example.com", false},
+
+		{[]string{"a.com", " b.com "}, "https://b.com", true},
+		{[]string{"*"}, "https://any.com", true},
+		{[]string{""}, "https://any.com", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := NewOriginChecker(tt.allowed...)(r); got != tt.want {
+			t.Errorf("%v %q: got %v, want %v", tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://example.com:8080", true},
+		{"https://Example.com:8080", true},
+		{"http://example.com", false},
+		{"http://example.com:9090", false},
+		{"http://evil.com:8080", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com:8080/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := CheckSameOrigin(r); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestUpgradeRejectsForeignOrigin(t *testing.T) {
+	cm := NewClientManage()
+	u := newTestServer(t, cm)
+
+	header := http.Header{"Origin": []string{"http://evil.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial(u, header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin: %v %v", resp, err)
+	}
+	if n := cm.GetClientCount(); n != 0 {
+		t.Fatalf("clients: %d", n)
+	}
+
+	//同源放行
+	header = http.Header{"Origin": []string{"http://" + strings.TrimPrefix(u, "ws://")}}
+	conn, _, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		t.Fatalf("same origin: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,311 @@
+package go_websocket
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 在线记录
+type PresenceEntry struct {
+	ClientId string   `json:"client_id"`
+	UserId   string   `json:"user_id,omitempty"`
+	SystemId string   `json:"system_id"`
+	Groups   []string `json:"groups,omitempty"`
+	Node     string   `json:"node"` //所在节点
+}
+
+// 在线状态存储，记录集群内所有客户端及其所在节点
+//
+// 记录归属于节点，节点超过 TTL 没有心跳时其记录自动失效
+type PresenceStore interface {
+	//添加或更新客户端记录，节点需通过 Heartbeat 保持有效
+	Add(ctx context.Context, entry PresenceEntry) error
+	//删除客户端记录
+	Remove(ctx context.Context, node string, clientId string) error
+	//节点心跳，返回心跳前节点是否有效。节点已失效时清空其记录，由调用方重新添加
+	Heartbeat(ctx context.Context, node string) (bool, error)
+	//删除节点及其所有记录
+	RemoveNode(ctx context.Context, node string) error
+	//获取客户端记录，不在线时返回 nil
+	Get(ctx context.Context, clientId string) (*PresenceEntry, error)
+	//所有在线记录
+	List(ctx context.Context) ([]PresenceEntry, error)
+}
+
+// 客户端的在线记录
+func (cm *ClientManage) presenceEntry(c *Client) PresenceEntry {
+	return PresenceEntry{
+		ClientId: c.GetID(),
+		UserId:   c.GetUserId(),
+		SystemId: c.GetSystemId(),
+		Groups:   c.GetGroups(),
+		Node:     cm.opts.NodeId,
+	}
+}
+
+// 同步客户端在线记录，客户端已删除时删除记录
+func (cm *ClientManage) syncPresence(c *Client) {
+	store := cm.opts.Presence
+	if store == nil {
+		return
+	}
+
+	c.presenceLock.Lock()
+	defer c.presenceLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cm.opts.WriteDeadline)
+	defer cancel()
+
+	var err error
+	if c.IsClosed() {
+		err = store.Remove(ctx, cm.opts.NodeId, c.GetID())
+	} else {
+		err = store.Add(ctx, cm.presenceEntry(c))
+	}
+	if err != nil {
+		Log.Error(c.ctx, "Presence Sync Error ", err)
+	}
+}
+
+// 定时发送节点心跳，ctx 取消后退出
+//
+// 节点记录已失效时（长时间没有心跳，如存储不可用）重新添加本节点的所有客户端
+func (cm *ClientManage) presenceHeartbeat(ctx context.Context) {
+	store := cm.opts.Presence
+	beat := func() {
+		hctx, cancel := context.WithTimeout(ctx, cm.opts.WriteDeadline)
+		defer cancel()
+		alive, err := store.Heartbeat(hctx, cm.opts.NodeId)
+		if err != nil {
+			Log.Error(ctx, "Presence Heartbeat Error ", err)
+		}
+		if !alive && err == nil {
+			for _, c := range cm.clients.snapshot() {
+				cm.syncPresence(c)
+			}
+		}
+	}
+
+	beat()
+	ticker := time.NewTicker(cm.opts.PresenceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			beat()
+		}
+	}
+}
+
+// 删除本节点的所有在线记录
+func (cm *ClientManage) removePresenceNode() {
+	store := cm.opts.Presence
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cm.opts.WriteDeadline)
+	defer cancel()
+	if err := store.RemoveNode(ctx, cm.opts.NodeId); err != nil {
+		Log.Error(ctx, "Presence RemoveNode Error ", err)
+	}
+}
+
+// 集群内的客户端记录，未配置在线状态存储时只查本节点，不在线时返回 nil
+func (cm *ClientManage) GetClusterClient(ctx context.Context, clientId string) (*PresenceEntry, error) {
+	if store := cm.opts.Presence; store != nil {
+		return store.Get(ctx, clientId)
+	}
+	c := cm.GetClientByID(clientId)
+	if c == nil {
+		return nil, nil
+	}
+	e := cm.presenceEntry(c)
+	return &e, nil
+}
+
+// 客户端是否在集群内在线
+func (cm *ClientManage) IsOnline(ctx context.Context, clientId string) (bool, error) {
+	e, err := cm.GetClusterClient(ctx, clientId)
+	return e != nil, err
+}
+
+// 集群内所有在线记录，未配置在线状态存储时只查本节点
+func (cm *ClientManage) GetClusterEntries(ctx context.Context) ([]PresenceEntry, error) {
+	if store := cm.opts.Presence; store != nil {
+		return store.List(ctx)
+	}
+	clients := cm.clients.snapshot()
+	list := make([]PresenceEntry, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, cm.presenceEntry(c))
+	}
+	return list, nil
+}
+
+// 集群内用户的在线记录
+func (cm *ClientManage) GetClusterUserClients(ctx context.Context, userId string) ([]PresenceEntry, error) {
+	entries, err := cm.GetClusterEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]PresenceEntry, 0)
+	for _, e := range entries {
+		if e.UserId == userId {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+// 集群内客户端列表
+func (cm *ClientManage) GetClusterClientList(ctx context.Context) ([]string, error) {
+	entries, err := cm.GetClusterEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.ClientId)
+	}
+	return list, nil
+}
+
+// 集群内系统列表
+func (cm *ClientManage) GetClusterSystemList(ctx context.Context) (map[string][]string, error) {
+	entries, err := cm.GetClusterEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string][]string)
+	for _, e := range entries {
+		if len(e.SystemId) > 0 {
+			list[e.SystemId] = append(list[e.SystemId], e.ClientId)
+		}
+	}
+	return list, nil
+}
+
+// 集群内组列表
+func (cm *ClientManage) GetClusterGroupsList(ctx context.Context) (map[string][]string, error) {
+	entries, err := cm.GetClusterEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string][]string)
+	for _, e := range entries {
+		for _, g := range e.Groups {
+			list[g] = append(list[g], e.ClientId)
+		}
+	}
+	return list, nil
+}
+
+// 内存在线状态存储，用于测试或同一进程内多个管理器
+type MemoryPresenceStore struct {
+	ttl   time.Duration
+	nodes map[string]*memoryPresenceNode
+	lock  sync.Mutex
+}
+
+type memoryPresenceNode struct {
+	expire  time.Time
+	clients map[string]PresenceEntry
+}
+
+// ttl 为节点心跳超时时间
+func NewMemoryPresenceStore(ttl time.Duration) *MemoryPresenceStore {
+	return &MemoryPresenceStore{
+		ttl:   ttl,
+		nodes: make(map[string]*memoryPresenceNode),
+	}
+}
+
+// 获取节点，不存在时创建，需持有锁
+func (s *MemoryPresenceStore) node(node string) *memoryPresenceNode {
+	n, ok := s.nodes[node]
+	if !ok {
+		n = &memoryPresenceNode{clients: make(map[string]PresenceEntry)}
+		s.nodes[node] = n
+	}
+	return n
+}
+
+// 删除心跳超时的节点，需持有锁
+func (s *MemoryPresenceStore) expire() {
+	now := time.Now()
+	for id, n := range s.nodes {
+		if now.After(n.expire) {
+			delete(s.nodes, id)
+		}
+	}
+}
+
+func (s *MemoryPresenceStore) Add(ctx context.Context, entry PresenceEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry.Groups = append([]string(nil), entry.Groups...)
+	s.node(entry.Node).clients[entry.ClientId] = entry
+	return nil
+}
+
+func (s *MemoryPresenceStore) Remove(ctx context.Context, node string, clientId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n, ok := s.nodes[node]; ok {
+		delete(n.clients, clientId)
+	}
+	return nil
+}
+
+func (s *MemoryPresenceStore) Heartbeat(ctx context.Context, node string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n, ok := s.nodes[node]
+	alive := ok && !time.Now().After(n.expire)
+	if !alive {
+		n = &memoryPresenceNode{clients: make(map[string]PresenceEntry)}
+		s.nodes[node] = n
+	}
+	n.expire = time.Now().Add(s.ttl)
+	s.expire()
+	return alive, nil
+}
+
+func (s *MemoryPresenceStore) RemoveNode(ctx context.Context, node string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.nodes, node)
+	return nil
+}
+
+func (s *MemoryPresenceStore) Get(ctx context.Context, clientId string) (*PresenceEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+	for _, n := range s.nodes {
+		if e, ok := n.clients[clientId]; ok {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryPresenceStore) List(ctx context.Context) ([]PresenceEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+	list := make([]PresenceEntry, 0)
+	for _, n := range s.nodes {
+		for _, e := range n.clients {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ClientId < list[j].ClientId
+	})
+	return list, nil
+}
@@ -0,0 +1,248 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 只在索引仍指向该节点时删除
+var presenceUnindexScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// 清理节点：删除仍指向该节点的索引、节点的客户端记录和心跳
+//
+// KEYS: clients, node, node ids, nodes；ARGV: node, 过期判断时间（为空时不判断）
+var presenceCleanScript = redis.NewScript(`
+if ARGV[2] ~= "" then
+	local score = redis.call("ZSCORE", KEYS[4], ARGV[1])
+	if score and tonumber(score) >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+for _, id in ipairs(redis.call("SMEMBERS", KEYS[3])) do
+	if redis.call("HGET", KEYS[1], id) == ARGV[1] then
+		redis.call("HDEL", KEYS[1], id)
+	end
+end
+redis.call("DEL", KEYS[2], KEYS[3])
+redis.call("ZREM", KEYS[4], ARGV[1])
+return 1`)
+
+// 节点心跳，节点已失效时先清理旧记录，返回心跳前节点是否有效
+//
+// KEYS: clients, node, node ids, nodes；ARGV: node, 当前时间, 过期时间, ttl 毫秒
+var presenceHeartbeatScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[4], ARGV[1])
+local alive = score and tonumber(score) >= tonumber(ARGV[2])
+if not alive then
+	for _, id in ipairs(redis.call("SMEMBERS", KEYS[3])) do
+		if redis.call("HGET", KEYS[1], id) == ARGV[1] then
+			redis.call("HDEL", KEYS[1], id)
+		end
+	end
+	redis.call("DEL", KEYS[2], KEYS[3])
+end
+redis.call("ZADD", KEYS[4], ARGV[3], ARGV[1])
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+redis.call("PEXPIRE", KEYS[3], ARGV[4])
+if alive then
+	return 1
+end
+return 0`)
+
+// Redis 在线状态存储
+//
+// prefix:nodes 为节点心跳的有序集合，分数为过期时间；
+// prefix:node:{node} 为节点的客户端记录，prefix:node:{node}:ids 为节点的客户端ID，随心跳续期；
+// prefix:clients 为客户端所在节点的索引，心跳时清理已失效节点的索引。
+//
+// 所有键使用 {prefix} 作为 hash tag，Redis Cluster 下位于同一个槽
+type RedisPresenceStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// prefix 为空时使用 "ws:presence"，ttl 为节点心跳超时时间
+//
+// prefix 不含 hash tag 时自动加上 {}
+func NewRedisPresenceStore(client redis.UniversalClient, prefix string, ttl time.Duration) *RedisPresenceStore {
+	if prefix == "" {
+		prefix = "ws:presence"
+	}
+	return &RedisPresenceStore{
+		client: client,
+		prefix: hashTag(prefix),
+		ttl:    ttl,
+	}
+}
+
+// 加上 hash tag，已包含时不处理
+func hashTag(prefix string) string {
+	if strings.Contains(prefix, "{") {
+		return prefix
+	}
+	return "{" + prefix + "}"
+}
+
+func (s *RedisPresenceStore) nodesKey() string {
+	return s.prefix + ":nodes"
+}
+
+func (s *RedisPresenceStore) nodeKey(node string) string {
+	return s.prefix + ":node:" + node
+}
+
+func (s *RedisPresenceStore) nodeIdsKey(node string) string {
+	return s.prefix + ":node:" + node + ":ids"
+}
+
+func (s *RedisPresenceStore) clientsKey() string {
+	return s.prefix + ":clients"
+}
+
+// 脚本用到的节点相关键
+func (s *RedisPresenceStore) nodeKeys(node string) []string {
+	return []string{s.clientsKey(), s.nodeKey(node), s.nodeIdsKey(node), s.nodesKey()}
+}
+
+// 当前时间的毫秒数
+func nowMilli() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+// 添加记录，节点的心跳由 Heartbeat 维护
+func (s *RedisPresenceStore) Add(ctx context.Context, entry PresenceEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, s.nodeKey(entry.Node), entry.ClientId, data)
+		p.PExpire(ctx, s.nodeKey(entry.Node), s.ttl)
+		p.SAdd(ctx, s.nodeIdsKey(entry.Node), entry.ClientId)
+		p.PExpire(ctx, s.nodeIdsKey(entry.Node), s.ttl)
+		p.HSet(ctx, s.clientsKey(), entry.ClientId, entry.Node)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisPresenceStore) Remove(ctx context.Context, node string, clientId string) error {
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HDel(ctx, s.nodeKey(node), clientId)
+		p.SRem(ctx, s.nodeIdsKey(node), clientId)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return presenceUnindexScript.Run(ctx, s.client, []string{s.clientsKey()}, clientId, node).Err()
+}
+
+// 节点心跳，同时清理其他已失效的节点
+func (s *RedisPresenceStore) Heartbeat(ctx context.Context, node string) (bool, error) {
+	expireAt := time.Now().Add(s.ttl).UnixMilli()
+	alive, err := presenceHeartbeatScript.Run(ctx, s.client, s.nodeKeys(node),
+		node, nowMilli(), expireAt, s.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return alive == 1, s.sweep(ctx)
+}
+
+// 清理心跳超时的节点
+func (s *RedisPresenceStore) sweep(ctx context.Context) error {
+	now := nowMilli()
+	nodes, err := s.client.ZRangeByScore(ctx, s.nodesKey(), &redis.ZRangeBy{Min: "-inf", Max: "(" + now}).Result()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := presenceCleanScript.Run(ctx, s.client, s.nodeKeys(node), node, now).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisPresenceStore) RemoveNode(ctx context.Context, node string) error {
+	return presenceCleanScript.Run(ctx, s.client, s.nodeKeys(node), node, "").Err()
+}
+
+func (s *RedisPresenceStore) Get(ctx context.Context, clientId string) (*PresenceEntry, error) {
+	node, err := s.client.HGet(ctx, s.clientsKey(), clientId).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//心跳超时但还未清理的节点视为离线
+	score, err := s.client.ZScore(ctx, s.nodesKey(), node).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if errors.Is(err, redis.Nil) || int64(score) < time.Now().UnixMilli() {
+		return nil, nil
+	}
+
+	data, err := s.client.HGet(ctx, s.nodeKey(node), clientId).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &PresenceEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *RedisPresenceStore) List(ctx context.Context) ([]PresenceEntry, error) {
+	//心跳超时的节点由 Heartbeat 清理，这里只过滤
+	nodes, err := s.client.ZRangeByScore(ctx, s.nodesKey(), &redis.ZRangeBy{Min: nowMilli(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, 0, len(nodes))
+	_, err = s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, node := range nodes {
+			cmds = append(cmds, p.HGetAll(ctx, s.nodeKey(node)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]PresenceEntry, 0)
+	for _, cmd := range cmds {
+		for _, v := range cmd.Val() {
+			entry := PresenceEntry{}
+			if err := json.Unmarshal([]byte(v), &entry); err != nil {
+				return nil, err
+			}
+			list = append(list, entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ClientId < list[j].ClientId
+	})
+	return list, nil
+}
@@ -0,0 +1,127 @@
+package go_websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+func TestPresenceStore(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	stores := map[string]PresenceStore{
+		"memory": NewMemoryPresenceStore(time.Minute),
+		"redis":  NewRedisPresenceStore(rdb, "", time.Minute),
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			alive, err := store.Heartbeat(ctx, "n1")
+			if err != nil || alive {
+				t.Fatalf("first Heartbeat: %v %v", alive, err)
+			}
+			store.Add(ctx, PresenceEntry{ClientId: "a", SystemId: "s", Groups: []string{"g"}, Node: "n1"})
+			store.Add(ctx, PresenceEntry{ClientId: "b", SystemId: "s", Node: "n1"})
+			if alive, err := store.Heartbeat(ctx, "n1"); err != nil || !alive {
+				t.Fatalf("Heartbeat: %v %v", alive, err)
+			}
+
+			e, err := store.Get(ctx, "a")
+			if err != nil || e == nil || e.Node != "n1" || len(e.Groups) != 1 {
+				t.Fatalf("Get: %+v %v", e, err)
+			}
+			list, err := store.List(ctx)
+			if err != nil || len(list) != 2 || list[0].ClientId != "a" {
+				t.Fatalf("List: %+v %v", list, err)
+			}
+
+			store.Remove(ctx, "n1", "a")
+			if e, _ := store.Get(ctx, "a"); e != nil {
+				t.Fatalf("Get after Remove: %+v", e)
+			}
+			store.RemoveNode(ctx, "n1")
+			if list, _ := store.List(ctx); len(list) != 0 {
+				t.Fatalf("List after RemoveNode: %+v", list)
+			}
+		})
+	}
+}
+
+func TestRedisPresenceHashTag(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	store := NewRedisPresenceStore(rdb, "ws:presence", time.Minute)
+	ctx := context.Background()
+	store.Heartbeat(ctx, "n1")
+	store.Add(ctx, PresenceEntry{ClientId: "a", Node: "n1"})
+	for _, key := range mr.Keys() {
+		if !strings.HasPrefix(key, "{ws:presence}:") {
+			t.Fatalf("key without hash tag: %s", key)
+		}
+	}
+}
+
+func TestRedisPresenceSweepCrashedNode(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	ctx := context.Background()
+	crashed := NewRedisPresenceStore(rdb, "", 50*time.Millisecond)
+	crashed.Heartbeat(ctx, "dead")
+	crashed.Add(ctx, PresenceEntry{ClientId: "a", Node: "dead"})
+	crashed.Add(ctx, PresenceEntry{ClientId: "b", Node: "dead"})
+
+	time.Sleep(100 * time.Millisecond)
+	if e, _ := crashed.Get(ctx, "a"); e != nil {
+		t.Fatalf("Get on expired node: %+v", e)
+	}
+
+	store := NewRedisPresenceStore(rdb, "", time.Minute)
+	if _, err := store.Heartbeat(ctx, "live"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := mr.HKeys(store.clientsKey()); len(keys) != 0 {
+		t.Fatalf("index not cleaned: %v", keys)
+	}
+	for _, key := range mr.Keys() {
+		if strings.Contains(key, "dead") {
+			t.Fatalf("crashed node key left: %s", key)
+		}
+	}
+}
+
+func TestPresenceRecoversAfterOutage(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	store := NewRedisPresenceStore(rdb, "", time.Minute)
+	cm := NewClientManage(WithNodeId("n1"), WithPresence(store, 20*time.Millisecond))
+	u := newTestServer(t, cm)
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	ctx := context.Background()
+	waitFor(t, 3*time.Second, func() bool {
+		list, _ := cm.GetClusterClientList(ctx)
+		return len(list) == 1
+	})
+
+	//模拟存储数据丢失，恢复心跳后重新添加本节点的客户端
+	mr.FlushAll()
+	waitFor(t, 3*time.Second, func() bool {
+		list, _ := cm.GetClusterClientList(ctx)
+		return len(list) == 1
+	})
+}
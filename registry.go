@@ -0,0 +1,100 @@
+package go_websocket
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// 分片数量
+const registryShardCount = 32
+
+// 客户端分片
+type registryShard struct {
+	clients map[string]*Client
+	lock    sync.RWMutex
+}
+
+// 客户端注册表，按客户端ID分片减少锁竞争
+type registry struct {
+	shards [registryShardCount]*registryShard
+}
+
+func newRegistry() *registry {
+	r := &registry{}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{clients: make(map[string]*Client)}
+	}
+	return r
+}
+
+func (r *registry) shard(id string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return r.shards[h.Sum32()%registryShardCount]
+}
+
+func (r *registry) get(id string) *Client {
+	s := r.shard(id)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.clients[id]
+}
+
+// 添加客户端，ID已存在时返回false
+func (r *registry) add(c *Client) bool {
+	s := r.shard(c.GetID())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.clients[c.GetID()]; ok {
+		return false
+	}
+	s.clients[c.GetID()] = c
+	return true
+}
+
+// 删除客户端，只删除同一个实例
+func (r *registry) remove(c *Client) bool {
+	s := r.shard(c.GetID())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.clients[c.GetID()]; !ok || old != c {
+		return false
+	}
+	delete(s.clients, c.GetID())
+	return true
+}
+
+// 替换客户端，只在当前实例为 old 时替换
+func (r *registry) replace(old *Client, c *Client) bool {
+	s := r.shard(c.GetID())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cur, ok := s.clients[c.GetID()]; !ok || cur != old {
+		return false
+	}
+	s.clients[c.GetID()] = c
+	return true
+}
+
+// 所有客户端快照
+func (r *registry) snapshot() []*Client {
+	list := make([]*Client, 0)
+	for _, s := range r.shards {
+		s.lock.RLock()
+		for _, c := range s.clients {
+			list = append(list, c)
+		}
+		s.lock.RUnlock()
+	}
+	return list
+}
+
+func (r *registry) count() int {
+	n := 0
+	for _, s := range r.shards {
+		s.lock.RLock()
+		n += len(s.clients)
+		s.lock.RUnlock()
+	}
+	return n
+}
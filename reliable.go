@@ -0,0 +1,351 @@
+package go_websocket
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrReliableUnsupported = errors.New("response does not support message id")
+	ErrReliableDisabled    = errors.New("reliable send disabled, set ack route with WithAckRoute")
+)
+
+// 可靠发送的最终状态
+type AckStatus string
+
+const (
+	AckStatusAcked   AckStatus = "acked"   //客户端已确认
+	AckStatusExpired AckStatus = "expired" //超过截止时间未确认
+	AckStatusClosed  AckStatus = "closed"  //客户端已断开或不在线
+	AckStatusError   AckStatus = "error"   //格式化或编码失败
+)
+
+// 可靠发送结果
+type AckResult struct {
+	MsgId    string    `json:"msg_id"`
+	ClientId string    `json:"client_id"`
+	Status   AckStatus `json:"status"`
+	Attempts int       `json:"attempts"` //发送次数，包含重传
+	Err      error     `json:"-"`
+}
+
+// 可靠发送结果回调
+type AckFunc func(result AckResult)
+
+// 重传策略，间隔从 InitialInterval 开始按 Multiplier 增长，不超过 MaxInterval
+type RetryPolicy struct {
+	InitialInterval time.Duration //首次重传间隔
+	MaxInterval     time.Duration //最大重传间隔
+	Multiplier      float64       //间隔增长倍数，小于1时按1处理
+	Deadline        time.Duration //发送后等待确认的截止时间
+}
+
+// 默认重传策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      2,
+		Deadline:        30 * time.Second,
+	}
+}
+
+// 下一次重传间隔
+func (p RetryPolicy) next(interval time.Duration) time.Duration {
+	if p.Multiplier > 1 {
+		interval = time.Duration(float64(interval) * p.Multiplier)
+	}
+	if p.MaxInterval > 0 && interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+	return interval
+}
+
+// 可带消息ID的响应，客户端按消息ID确认和去重
+type IMsgIdResponse interface {
+	SetMsgId(id string)
+}
+
+// 确认请求的参数
+type AckParams struct {
+	MsgId string `json:"msg_id"`
+}
+
+var errAckMsgIdRequired = errors.New("ack msg_id required")
+
+// 解析确认参数，protobuf 编解码器按确认消息的字段解析
+func (c *Client) decodeAckParams(req IRequest) (AckParams, error) {
+	var params AckParams
+	var err error
+	if _, ok := c.codec.(ProtobufCodec); ok {
+		params, err = decodeProtobufAck(req)
+	} else {
+		err = c.DecodeParams(req.GetParams(), &params)
+	}
+	if err == nil && len(params.MsgId) <= 0 {
+		err = errAckMsgIdRequired
+	}
+	return params, err
+}
+
+// 等待确认的消息
+type pendingAck struct {
+	msgId    string
+	clientId string
+	res      IResponse
+	fn       AckFunc
+	attempts int
+	interval time.Duration
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// 可靠发送，响应带上消息ID，未收到确认时按重传策略重传，结果通过 fn 回调
+//
+// 需通过 WithAckRoute 开启，未开启时结果为 error。客户端处理后需发送确认请求，
+// 参数为 {"msg_id":"消息ID"}，重传的消息ID不变，客户端可按消息ID去重。返回消息ID
+func (c *Client) SendMsgReliable(msg []byte, fn AckFunc) string {
+	cm := c.clientManage
+	return cm.sendReliable(c, cm.opts.IDGenerator.Generate(), msg, fn)
+}
+
+// 给多个客户端可靠发送，所有客户端的结果都返回后关闭通道
+//
+// 只发送给本节点的客户端，不在本节点的客户端结果为 closed
+func (cm *ClientManage) SendClientMsgReliable(msg []byte, clientIds ...string) <-chan AckResult {
+	ch := make(chan AckResult, len(clientIds))
+	if len(clientIds) <= 0 {
+		close(ch)
+		return ch
+	}
+
+	//同一客户端只发送一次
+	seen := make(map[string]struct{}, len(clientIds))
+	ids := make([]string, 0, len(clientIds))
+	for _, id := range clientIds {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	msgId := cm.opts.IDGenerator.Generate()
+	remaining := int32(len(ids))
+	fn := func(result AckResult) {
+		ch <- result
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			close(ch)
+		}
+	}
+
+	for _, id := range ids {
+		c := cm.GetClientByID(id)
+		if c == nil {
+			fn(AckResult{MsgId: msgId, ClientId: id, Status: AckStatusClosed, Err: ErrClientClosed})
+			continue
+		}
+		cm.sendReliable(c, msgId, msg, fn)
+	}
+	return ch
+}
+
+// 格式化、登记并首次发送
+func (cm *ClientManage) sendReliable(c *Client, msgId string, msg []byte, fn AckFunc) string {
+	result := AckResult{MsgId: msgId, ClientId: c.GetID()}
+	if len(cm.opts.AckRoute) <= 0 {
+		result.Status, result.Err = AckStatusError, ErrReliableDisabled
+		cm.ackResult(fn, result)
+		return msgId
+	}
+
+	res, err := c.responseFormatFunc()(c, msg)
+	if err != nil {
+		Log.Error(c.ctx, "resFormatFn Error ", err)
+		result.Status, result.Err = AckStatusError, err
+		cm.ackResult(fn, result)
+		return msgId
+	}
+	r, ok := res.(IMsgIdResponse)
+	if !ok {
+		result.Status, result.Err = AckStatusError, ErrReliableUnsupported
+		cm.ackResult(fn, result)
+		return msgId
+	}
+	r.SetMsgId(msgId)
+	if p, ok := res.(IPushResponse); ok {
+		p.SetPush()
+	}
+
+	policy := cm.opts.RetryPolicy
+	p := &pendingAck{
+		msgId:    msgId,
+		clientId: c.GetID(),
+		res:      res,
+		fn:       fn,
+		attempts: 1,
+		interval: policy.InitialInterval,
+		deadline: time.Now().Add(policy.Deadline),
+	}
+
+	//先登记再发送，避免确认先于登记到达
+	cm.acksLock.Lock()
+	if cm.acks[p.clientId] == nil {
+		cm.acks[p.clientId] = make(map[string]*pendingAck)
+	}
+	cm.acks[p.clientId][msgId] = p
+	cm.acksLock.Unlock()
+
+	//队列满时等待重传
+	if err := c.SendResponse(res); err != nil {
+		switch dropReason(err) {
+		case DropReasonOffline:
+			cm.finishAck(p, AckStatusClosed, err)
+			return msgId
+		case DropReasonError:
+			cm.finishAck(p, AckStatusError, err)
+			return msgId
+		}
+		Log.Error(c.ctx, "SendMsgReliable Error ", err)
+	}
+
+	//首次发送后再启动重传，重传时响应已带上序号
+	cm.acksLock.Lock()
+	if cm.acks[p.clientId][msgId] == p {
+		p.timer = time.AfterFunc(cm.retryDelay(p), func() {
+			cm.retransmit(p)
+		})
+	}
+	cm.acksLock.Unlock()
+	return msgId
+}
+
+// 下一次检查的等待时间，不超过截止时间
+func (cm *ClientManage) retryDelay(p *pendingAck) time.Duration {
+	d := p.interval
+	if left := time.Until(p.deadline); left < d {
+		d = left
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// 重传，超过截止时间或客户端已删除时结束
+func (cm *ClientManage) retransmit(p *pendingAck) {
+	cm.acksLock.Lock()
+	if cm.acks[p.clientId][p.msgId] != p {
+		cm.acksLock.Unlock()
+		return
+	}
+	if !time.Now().Before(p.deadline) {
+		cm.acksLock.Unlock()
+		cm.finishAck(p, AckStatusExpired, nil)
+		return
+	}
+	c := cm.GetClientByID(p.clientId)
+	if c == nil {
+		cm.acksLock.Unlock()
+		cm.finishAck(p, AckStatusClosed, ErrClientClosed)
+		return
+	}
+	p.attempts++
+	p.interval = cm.opts.RetryPolicy.next(p.interval)
+	p.timer = time.AfterFunc(cm.retryDelay(p), func() {
+		cm.retransmit(p)
+	})
+	cm.acksLock.Unlock()
+
+	//重传原样发送，不重新编号，断开等待恢复或队列满时等待下次重传
+	bytes, msgType, err := c.encodeResponse(p.res)
+	if err != nil {
+		Log.Error(c.ctx, "EncodeResponse Error ", err)
+		cm.finishAck(p, AckStatusError, err)
+		return
+	}
+	if err := c.enqueue(newOutMessage(msgType, bytes)); err != nil {
+		Log.Error(c.ctx, "Retransmit Error ", err)
+	}
+}
+
+// 处理客户端的确认，未知或重复的确认忽略，参数无法解析时返回错误响应
+func (cm *ClientManage) ack(c *Client, req IRequest) error {
+	params, err := c.decodeAckParams(req)
+	if err != nil {
+		c.SendError(req, ErrBadRequest.WithDetails(err.Error()))
+		return err
+	}
+	cm.acksLock.Lock()
+	p, ok := cm.acks[c.GetID()][params.MsgId]
+	cm.acksLock.Unlock()
+	if ok {
+		cm.finishAck(p, AckStatusAcked, nil)
+	}
+	return nil
+}
+
+// 结束等待，只回调一次
+func (cm *ClientManage) finishAck(p *pendingAck, status AckStatus, err error) {
+	cm.acksLock.Lock()
+	if cm.acks[p.clientId][p.msgId] != p {
+		cm.acksLock.Unlock()
+		return
+	}
+	delete(cm.acks[p.clientId], p.msgId)
+	if len(cm.acks[p.clientId]) <= 0 {
+		delete(cm.acks, p.clientId)
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	attempts := p.attempts
+	cm.acksLock.Unlock()
+
+	cm.ackResult(p.fn, AckResult{
+		MsgId:    p.msgId,
+		ClientId: p.clientId,
+		Status:   status,
+		Attempts: attempts,
+		Err:      err,
+	})
+}
+
+// 客户端删除时结束其所有等待确认的消息
+func (cm *ClientManage) closePendingAcks(clientId string) {
+	cm.acksLock.Lock()
+	list := make([]*pendingAck, 0, len(cm.acks[clientId]))
+	for _, p := range cm.acks[clientId] {
+		list = append(list, p)
+	}
+	cm.acksLock.Unlock()
+
+	for _, p := range list {
+		cm.finishAck(p, AckStatusClosed, ErrClientClosed)
+	}
+}
+
+// 待确认的消息数
+func (cm *ClientManage) GetPendingAckCount() int {
+	cm.acksLock.Lock()
+	defer cm.acksLock.Unlock()
+	n := 0
+	for _, list := range cm.acks {
+		n += len(list)
+	}
+	return n
+}
+
+// 触发结果回调
+func (cm *ClientManage) ackResult(fn AckFunc, result AckResult) {
+	if fn == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			Log.Error(context.Background(), "AckFunc Panic ", err)
+		}
+	}()
+	fn(result)
+}
@@ -0,0 +1,168 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestAckRouteDisabledByDefault(t *testing.T) {
+	h := NewClientHandler()
+	h.Register("/ack", func(ctx context.Context, c *Client, p interface{}) (IResponse, error) {
+		return NewOkClientRes("app"), nil
+	})
+	cm := NewClientManage(WithClientHandler(h))
+	u := newTestServer(t, cm)
+	conn, c := dialClient(t, cm, u)
+
+	//未开启可靠发送时 /ack 由应用路由处理
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","url":"/ack"}`))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ClientResponse
+	json.Unmarshal(data, &res)
+	if res.Data != "app" {
+		t.Fatalf("unexpected response %s", data)
+	}
+
+	results := make(chan AckResult, 1)
+	msg, _ := NewOkClientRes("x").GetBytes()
+	c.SendMsgReliable(msg, func(r AckResult) { results <- r })
+	if r := <-results; r.Status != AckStatusError || r.Err != ErrReliableDisabled {
+		t.Fatalf("unexpected result %+v", r)
+	}
+}
+
+func TestSendMsgReliableAcked(t *testing.T) {
+	cm := NewClientManage(WithAckRoute(AckRoute))
+	u := newTestServer(t, cm)
+	conn, c := dialClient(t, cm, u)
+
+	msg, _ := NewOkClientRes("x").GetBytes()
+	ch := cm.SendClientMsgReliable(msg, c.GetID())
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ClientResponse
+	json.Unmarshal(data, &res)
+	if res.MsgId == "" {
+		t.Fatalf("missing msg_id %s", data)
+	}
+	ack, _ := json.Marshal(map[string]interface{}{"url": AckRoute, "params": AckParams{MsgId: res.MsgId}})
+	conn.WriteMessage(websocket.TextMessage, ack)
+
+	select {
+	case r := <-ch:
+		if r.Status != AckStatusAcked || r.Attempts != 1 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no ack result")
+	}
+	if n := cm.GetPendingAckCount(); n != 0 {
+		t.Fatalf("pending acks: %d", n)
+	}
+}
+
+// 解析 protobuf 消息的 bytes 和 varint 字段，同一字段取最后一个
+func protobufFields(t *testing.T, data []byte) map[protowire.Number]interface{} {
+	t.Helper()
+	fields := make(map[protowire.Number]interface{})
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			fields[num] = v
+			data = data[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			fields[num] = v
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	return fields
+}
+
+func TestSendMsgReliableProtobufAck(t *testing.T) {
+	cm := NewClientManage(WithAckRoute(AckRoute))
+	u := newTestServer(t, cm)
+	conn, c := dialClient(t, cm, u+"?codec=protobuf")
+
+	msg, _ := NewOkClientRes("x").GetBytes()
+	ch := cm.SendClientMsgReliable(msg, c.GetID())
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	msgType, data, err := conn.ReadMessage()
+	if err != nil || msgType != websocket.BinaryMessage {
+		t.Fatalf("ReadMessage: %d %v", msgType, err)
+	}
+	msgId, _ := protobufFields(t, data)[8].([]byte)
+	if len(msgId) <= 0 {
+		t.Fatalf("missing msg_id %x", data)
+	}
+
+	var params []byte
+	params = protowire.AppendTag(params, 1, protowire.BytesType)
+	params = protowire.AppendBytes(params, msgId)
+	var req []byte
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendString(req, AckRoute)
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendBytes(req, params)
+	conn.WriteMessage(websocket.BinaryMessage, req)
+
+	select {
+	case r := <-ch:
+		if r.Status != AckStatusAcked || r.Attempts != 1 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no ack result")
+	}
+}
+
+func TestAckBadParams(t *testing.T) {
+	cm := NewClientManage(WithAckRoute(AckRoute))
+	u := newTestServer(t, cm)
+	conn, _ := dialClient(t, cm, u)
+
+	for _, req := range []string{
+		`{"id":"1","url":"/ack","params":"x"}`,
+		`{"id":"1","url":"/ack","params":{}}`,
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(req))
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res ClientResponse
+		json.Unmarshal(data, &res)
+		if res.Code != 400 || res.Id != "1" {
+			t.Fatalf("unexpected response %s", data)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package go_websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 恢复会话的查询参数
+const (
+	ResumeTokenQuery = "resume_token" //会话令牌
+	LastSeqQuery     = "last_seq"     //最后收到的消息序号
+)
+
+// 会话信息，连接后推送给客户端，类型为 session
+type SessionInfo struct {
+	Token    string `json:"token"`          //会话令牌，重连时通过 resume_token 带上
+	ClientId string `json:"client_id"`      //客户端ID
+	Seq      uint64 `json:"seq"`            //当前最后一条消息的序号
+	Resumed  bool   `json:"resumed"`        //是否恢复了会话
+	Lost     bool   `json:"lost,omitempty"` //部分未收到的消息已不在缓冲区中，无法补发
+}
+
+// 缓冲区中已编号的消息
+type sessionEntry struct {
+	seq uint64
+	msg *outMessage
+}
+
+// 可恢复的会话，断开后在恢复窗口内保留客户端ID、身份、组、系统和已发送的消息
+type session struct {
+	token    string
+	clientId string
+	userId   string
+	client   *Client //当前的客户端
+	seq      uint64  //最后一条消息的序号
+	buffer   []sessionEntry
+	head     int
+	size     int
+	detached bool //连接已断开，等待恢复
+	claimed  bool //正在恢复
+	ended    bool //已结束
+	timer    *time.Timer
+	lock     sync.Mutex
+}
+
+// 生成会话令牌
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 加入缓冲区，满时覆盖最旧的消息，需持有锁
+func (s *session) push(e sessionEntry) {
+	if len(s.buffer) <= 0 {
+		return
+	}
+	idx := (s.head + s.size) % len(s.buffer)
+	if s.size == len(s.buffer) {
+		s.head = (s.head + 1) % len(s.buffer)
+	} else {
+		s.size++
+	}
+	s.buffer[idx] = e
+}
+
+// 序号大于 lastSeq 的消息，lost 表示部分消息已被覆盖，需持有锁
+func (s *session) since(lastSeq uint64) (list []sessionEntry, lost bool) {
+	if lastSeq >= s.seq {
+		return nil, false
+	}
+	for i := 0; i < s.size; i++ {
+		e := s.buffer[(s.head+i)%len(s.buffer)]
+		if e.seq > lastSeq {
+			list = append(list, e)
+		}
+	}
+	lost = len(list) <= 0 || list[0].seq > lastSeq+1
+	return list, lost
+}
+
+// 编号、缓存并发送，断开期间只缓存
+func (s *session) send(c *Client, res IResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.client != c {
+		return ErrClientClosed
+	}
+
+	seq := s.seq + 1
+	res.(ISeqResponse).SetSeq(seq)
+	bytes, msgType, err := c.encodeResponse(res)
+	if err != nil {
+		Log.Error(c.ctx, "EncodeResponse Error ", err)
+		return err
+	}
+
+	s.seq = seq
+	m := newOutMessage(msgType, bytes)
+	s.push(sessionEntry{seq: seq, msg: m})
+	if s.detached {
+		return nil
+	}
+	return c.enqueue(m)
+}
+
+// 是否已断开等待恢复
+func (c *Client) isDetached() bool {
+	return atomic.LoadInt32(&c.detached) == 1
+}
+
+// 会话令牌，未开启会话恢复时为空
+func (c *Client) GetSessionToken() string {
+	if c.session == nil {
+		return ""
+	}
+	return c.session.token
+}
+
+// 推送会话信息，不编号
+func (c *Client) sendSessionInfo(info SessionInfo) error {
+	res := &ClientResponse{
+		Type: ResponseTypeSession,
+		Code: 200,
+		Msg:  "成功",
+		Data: info,
+	}
+	bytes, msgType, err := c.encodeResponse(res)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(newOutMessage(msgType, bytes))
+}
+
+// 给新客户端创建会话，需在注册前调用，返回时持有会话锁
+func (cm *ClientManage) newSession(c *Client) (*session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		token:    token,
+		clientId: c.GetID(),
+		userId:   c.GetUserId(),
+		client:   c,
+		buffer:   make([]sessionEntry, cm.opts.ResumeBufferSize),
+	}
+	s.lock.Lock()
+	c.session = s
+
+	cm.sessionsLock.Lock()
+	cm.sessions[token] = s
+	cm.sessionsLock.Unlock()
+	return s, nil
+}
+
+// 认领等待恢复的会话，鉴权时用户必须一致
+func (cm *ClientManage) claimSession(token string, identity *Identity) *session {
+	cm.sessionsLock.Lock()
+	s, ok := cm.sessions[token]
+	cm.sessionsLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.detached || s.claimed || s.ended {
+		return nil
+	}
+	if identity != nil && identity.UserId != s.userId {
+		return nil
+	}
+	s.claimed = true
+	s.timer.Stop()
+	return s
+}
+
+// 放弃认领，重新等待恢复
+func (cm *ClientManage) releaseSession(s *session) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.claimed = false
+	s.timer = time.AfterFunc(cm.opts.ResumeWindow, func() {
+		cm.expireSession(s)
+	})
+}
+
+// 连接断开时保留会话，返回false时按正常断开处理
+//
+// 对方正常关闭、服务端主动关闭或管理器已关闭时不保留
+func (cm *ClientManage) detachSession(c *Client, err error) bool {
+	s := c.session
+	if s == nil || c.IsClosing() || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return false
+	}
+
+	//持有会话锁检查，保证关闭时 closeSessions 能看到已断开的会话
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client != c || s.detached || s.ended || cm.IsClosed() {
+		return false
+	}
+	s.detached = true
+	atomic.StoreInt32(&c.detached, 1)
+	s.timer = time.AfterFunc(cm.opts.ResumeWindow, func() {
+		cm.expireSession(s)
+	})
+	return true
+}
+
+// 恢复窗口结束，删除客户端
+func (cm *ClientManage) expireSession(s *session) {
+	s.lock.Lock()
+	if !s.detached || s.claimed || s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	c := s.client
+	s.lock.Unlock()
+
+	cm.UnRegister(c)
+}
+
+// 恢复会话，新客户端替换断开的客户端并补发序号大于 lastSeq 的消息
+func (cm *ClientManage) resumeSession(s *session, c *Client, lastSeq uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old := s.client
+	c.session = s
+	//沿用回调队列和连接时间，恢复不触发连接、断开回调
+	c.hooks = old.hooks
+	c.connectedAt = old.connectedAt
+	if !cm.clients.replace(old, c) {
+		cm.clients.add(c)
+	}
+
+	//替换组、系统、用户索引
+	cm.indexLock.Lock()
+	old.registered = false
+	cm.removeIndex(cm.systems, old.GetSystemId(), old)
+	for _, g := range old.GetGroups() {
+		cm.removeIndex(cm.groups, g, old)
+	}
+	cm.removeIndex(cm.users, old.GetUserId(), old)
+	c.registered = true
+	cm.addIndex(cm.systems, c.GetSystemId(), c)
+	for _, g := range c.GetGroups() {
+		cm.addIndex(cm.groups, g, c)
+	}
+	cm.addIndex(cm.users, c.GetUserId(), c)
+	cm.indexLock.Unlock()
+
+	old.markClosed()
+	s.client = c
+	s.detached = false
+	s.claimed = false
+	cm.syncPresence(c)
+
+	if err := cm.startClient(c); err != nil {
+		return err
+	}
+
+	list, lost := s.since(lastSeq)
+	c.sendSessionInfo(SessionInfo{
+		Token:    s.token,
+		ClientId: c.GetID(),
+		Seq:      s.seq,
+		Resumed:  true,
+		Lost:     lost,
+	})
+	for _, e := range list {
+		if err := c.enqueue(e.msg); err != nil {
+			Log.Error(c.ctx, "Replay Session Error ", err)
+			break
+		}
+	}
+	return nil
+}
+
+// 客户端删除时结束会话
+func (cm *ClientManage) endSession(c *Client) {
+	s := c.session
+	if s == nil {
+		return
+	}
+	cm.sessionsLock.Lock()
+	defer cm.sessionsLock.Unlock()
+	if cm.sessions[s.token] == s {
+		delete(cm.sessions, s.token)
+	}
+}
+
+// 关闭时删除所有等待恢复的客户端
+func (cm *ClientManage) closeSessions() {
+	cm.sessionsLock.Lock()
+	list := make([]*session, 0, len(cm.sessions))
+	for _, s := range cm.sessions {
+		list = append(list, s)
+	}
+	cm.sessionsLock.Unlock()
+
+	for _, s := range list {
+		s.lock.Lock()
+		if !s.detached || s.claimed || s.ended {
+			s.lock.Unlock()
+			continue
+		}
+		s.ended = true
+		s.timer.Stop()
+		c := s.client
+		s.lock.Unlock()
+
+		cm.UnRegister(c)
+	}
+}
+
+// 解析最后收到的消息序号
+func parseLastSeq(v string) uint64 {
+	seq, _ := strconv.ParseUint(v, 10, 64)
+	return seq
+}
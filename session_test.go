@@ -0,0 +1,208 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 读取会话信息
+func readSession(t *testing.T, conn *websocket.Conn) SessionInfo {
+	t.Helper()
+	res := readPush(t, conn)
+	if res.Type != ResponseTypeSession {
+		t.Fatalf("expected session info, got %+v", res)
+	}
+	data, _ := json.Marshal(res.Data)
+	var info SessionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// 异常断开并等待会话进入等待恢复状态
+func detach(t *testing.T, cm *ClientManage, conn *websocket.Conn, id string) {
+	t.Helper()
+	c := cm.GetClientByID(id)
+	conn.Close()
+	waitFor(t, 3*time.Second, c.isDetached)
+}
+
+func resumeURL(u string, info SessionInfo, lastSeq uint64) string {
+	return fmt.Sprintf("%s?%s=%s&%s=%d", u, ResumeTokenQuery, info.Token, LastSeqQuery, lastSeq)
+}
+
+func dialURL(t *testing.T, u string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestSessionResume(t *testing.T) {
+	cm := NewClientManage(WithSessionResume(time.Minute, 16))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u+"?group=g1&system_id=s1")
+	info := readSession(t, conn)
+	if info.Resumed || info.Seq != 0 || len(info.Token) <= 0 {
+		t.Fatalf("unexpected session %+v", info)
+	}
+	for i := 1; i <= 3; i++ {
+		msg, _ := NewOkClientRes(fmt.Sprint("m", i)).GetBytes()
+		cm.SendClientMsg(msg, info.ClientId)
+		if res := readPush(t, conn); res.Seq != uint64(i) {
+			t.Fatalf("unexpected push %+v", res)
+		}
+	}
+
+	detach(t, cm, conn, info.ClientId)
+	//等待恢复期间客户端保留，消息只缓存
+	if n := cm.GetClientCount(); n != 1 {
+		t.Fatalf("clients: %d", n)
+	}
+	msg, _ := NewOkClientRes("m4").GetBytes()
+	cm.SendGroupMsg(msg, "g1")
+
+	conn = dialURL(t, resumeURL(u, info, 1))
+	resumed := readSession(t, conn)
+	if !resumed.Resumed || resumed.Lost || resumed.ClientId != info.ClientId || resumed.Token != info.Token || resumed.Seq != 4 {
+		t.Fatalf("unexpected session %+v", resumed)
+	}
+	for i := 2; i <= 4; i++ {
+		if res := readPush(t, conn); res.Seq != uint64(i) || res.Data != fmt.Sprint("m", i) {
+			t.Fatalf("unexpected replay %+v", res)
+		}
+	}
+
+	//沿用客户端ID、系统和组，索引指向新客户端
+	c := cm.GetClientByID(info.ClientId)
+	if c == nil || c.isDetached() || c.GetSystemId() != "s1" {
+		t.Fatalf("unexpected client %+v", c)
+	}
+	if g := cm.GetGroupsList()["g1"]; len(g) != 1 || g[0] != info.ClientId {
+		t.Fatalf("groups %v", cm.GetGroupsList())
+	}
+	if s := cm.GetSystemList()["s1"]; len(s) != 1 || s[0] != info.ClientId {
+		t.Fatalf("systems %v", cm.GetSystemList())
+	}
+	msg, _ = NewOkClientRes("m5").GetBytes()
+	if report := cm.SendGroupMsg(msg, "g1"); len(report.Enqueued) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if res := readPush(t, conn); res.Seq != 5 || res.Data != "m5" {
+		t.Fatalf("unexpected push %+v", res)
+	}
+}
+
+func TestSessionResumeLost(t *testing.T) {
+	cm := NewClientManage(WithSessionResume(time.Minute, 2))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u)
+	info := readSession(t, conn)
+	detach(t, cm, conn, info.ClientId)
+	for i := 1; i <= 4; i++ {
+		msg, _ := NewOkClientRes(fmt.Sprint("m", i)).GetBytes()
+		cm.SendClientMsg(msg, info.ClientId)
+	}
+
+	conn = dialURL(t, resumeURL(u, info, 0))
+	resumed := readSession(t, conn)
+	if !resumed.Resumed || !resumed.Lost || resumed.Seq != 4 {
+		t.Fatalf("unexpected session %+v", resumed)
+	}
+	for i := 3; i <= 4; i++ {
+		if res := readPush(t, conn); res.Seq != uint64(i) {
+			t.Fatalf("unexpected replay %+v", res)
+		}
+	}
+}
+
+func TestSessionResumeOtherUser(t *testing.T) {
+	auth := AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+		return &Identity{UserId: r.URL.Query().Get("user"), SystemId: "s"}, nil
+	})
+	cm := NewClientManage(WithAuthenticator(auth), WithSessionResume(time.Minute, 16))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u+"?user=u1")
+	info := readSession(t, conn)
+	detach(t, cm, conn, info.ClientId)
+
+	//其他用户拿到令牌也不能恢复
+	conn = dialURL(t, resumeURL(u, info, 0)+"&user=u2")
+	other := readSession(t, conn)
+	if other.Resumed || other.ClientId == info.ClientId || other.Token == info.Token {
+		t.Fatalf("resumed by other user %+v", other)
+	}
+
+	//原会话仍可由同一用户恢复
+	conn = dialURL(t, resumeURL(u, info, 0)+"&user=u1")
+	if resumed := readSession(t, conn); !resumed.Resumed || resumed.ClientId != info.ClientId {
+		t.Fatalf("unexpected session %+v", resumed)
+	}
+	if c := cm.GetClientByID(info.ClientId); c.GetUserId() != "u1" {
+		t.Fatalf("user %s", c.GetUserId())
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	cm := NewClientManage(WithSessionResume(200*time.Millisecond, 16))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u+"?group=g1")
+	info := readSession(t, conn)
+	detach(t, cm, conn, info.ClientId)
+	waitFor(t, 3*time.Second, func() bool {
+		return cm.GetClientCount() == 0
+	})
+	if g := cm.GetGroupsList(); len(g) != 0 {
+		t.Fatalf("groups left %v", g)
+	}
+
+	conn = dialURL(t, resumeURL(u, info, 0))
+	if s := readSession(t, conn); s.Resumed || s.ClientId == info.ClientId {
+		t.Fatalf("resumed after window %+v", s)
+	}
+}
+
+func TestSessionNormalClose(t *testing.T) {
+	cm := NewClientManage(WithSessionResume(time.Minute, 16))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u)
+	readSession(t, conn)
+	//正常关闭不保留会话
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	waitFor(t, 3*time.Second, func() bool {
+		return cm.GetClientCount() == 0
+	})
+}
+
+func TestSessionShutdown(t *testing.T) {
+	cm := NewClientManage(WithSessionResume(time.Hour, 16))
+	u := newTestServer(t, cm)
+
+	conn := dialURL(t, u)
+	info := readSession(t, conn)
+	detach(t, cm, conn, info.ClientId)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := cm.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if n := cm.GetClientCount(); n != 0 {
+		t.Fatalf("clients left: %d", n)
+	}
+}
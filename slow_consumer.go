@@ -0,0 +1,168 @@
+package go_websocket
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrQueueFull    = errors.New("send queue full")
+	ErrSlowConsumer = errors.New("slow consumer disconnected")
+)
+
+// 发送队列满时的处理策略
+type SlowConsumerPolicy int8
+
+const (
+	SlowConsumerDropNewest SlowConsumerPolicy = iota //丢弃新消息
+	SlowConsumerDropOldest                           //丢弃队列中最旧的消息
+	SlowConsumerDisconnect                           //断开连接
+	SlowConsumerBlock                                //阻塞等待，超时后丢弃新消息
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerDropNewest:
+		return "drop_newest"
+	case SlowConsumerDropOldest:
+		return "drop_oldest"
+	case SlowConsumerDisconnect:
+		return "disconnect"
+	case SlowConsumerBlock:
+		return "block"
+	}
+	return ""
+}
+
+// 慢消费者回调
+type SlowConsumerFunc func(c *Client, policy SlowConsumerPolicy)
+
+// 慢消费者统计
+type SlowConsumerStats struct {
+	DroppedNewest uint64 //丢弃的新消息数
+	DroppedOldest uint64 //丢弃的旧消息数
+	Disconnected  uint64 //断开的客户端数
+	Blocked       uint64 //阻塞等待次数
+	BlockTimeouts uint64 //阻塞超时次数
+}
+
+type slowConsumerCounter struct {
+	droppedNewest uint64
+	droppedOldest uint64
+	disconnected  uint64
+	blocked       uint64
+	blockTimeouts uint64
+}
+
+// 慢消费者统计
+func (cm *ClientManage) GetSlowConsumerStats() SlowConsumerStats {
+	return SlowConsumerStats{
+		DroppedNewest: atomic.LoadUint64(&cm.slowConsumer.droppedNewest),
+		DroppedOldest: atomic.LoadUint64(&cm.slowConsumer.droppedOldest),
+		Disconnected:  atomic.LoadUint64(&cm.slowConsumer.disconnected),
+		Blocked:       atomic.LoadUint64(&cm.slowConsumer.blocked),
+		BlockTimeouts: atomic.LoadUint64(&cm.slowConsumer.blockTimeouts),
+	}
+}
+
+// 触发慢消费者回调
+func (cm *ClientManage) onSlowConsumer(c *Client, policy SlowConsumerPolicy) {
+	fn := cm.opts.OnSlowConsumer
+	if fn == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			Log.Error(c.ctx, "OnSlowConsumer Panic ", err)
+		}
+	}()
+	fn(c, policy)
+}
+
+// 消息入队，队列满时按管理器的慢消费者策略处理
+func (c *Client) enqueue(data *outMessage) error {
+	if c.IsClosed() || c.isDetached() {
+		return ErrClientClosed
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	cm := c.clientManage
+	opts := cm.opts
+	counter := &cm.slowConsumer
+
+	switch opts.SlowConsumerPolicy {
+	case SlowConsumerDropOldest:
+		cm.onSlowConsumer(c, SlowConsumerDropOldest)
+		for {
+			select {
+			case <-c.send:
+				atomic.AddUint64(&counter.droppedOldest, 1)
+			default:
+			}
+			select {
+			case c.send <- data:
+				return nil
+			case <-c.done:
+				return ErrClientClosed
+			default:
+			}
+		}
+	case SlowConsumerDisconnect:
+		//同一个客户端只统计、回调一次
+		if atomic.CompareAndSwapInt32(&c.slowKicked, 0, 1) {
+			atomic.AddUint64(&counter.disconnected, 1)
+			cm.onSlowConsumer(c, SlowConsumerDisconnect)
+			c.Kick(opts.SlowConsumerCloseCode, "slow consumer")
+		}
+		return ErrSlowConsumer
+	case SlowConsumerBlock:
+		atomic.AddUint64(&counter.blocked, 1)
+		cm.onSlowConsumer(c, SlowConsumerBlock)
+		timer := time.NewTimer(opts.SlowConsumerTimeout)
+		defer timer.Stop()
+		select {
+		case c.send <- data:
+			return nil
+		case <-c.done:
+			return ErrClientClosed
+		case <-timer.C:
+			atomic.AddUint64(&counter.blockTimeouts, 1)
+			return ErrQueueFull
+		}
+	default:
+		atomic.AddUint64(&counter.droppedNewest, 1)
+		cm.onSlowConsumer(c, SlowConsumerDropNewest)
+		return ErrQueueFull
+	}
+}
+
+// 立即发送关闭帧并断开，不等待发送队列
+//
+// 写循环可能正阻塞在写入上并持有连接的写锁，关闭帧在后台发送，不阻塞调用方
+func (c *Client) Kick(code int, reason string) {
+	c.setCloseStatus(code, reason)
+	c.kickOnce.Do(func() {
+		c.stateLock.Lock()
+		c.closing = true
+		c.stateLock.Unlock()
+
+		if c.conn == nil {
+			return
+		}
+		go func() {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+				time.Now().Add(c.clientManage.opts.WriteDeadline))
+			c.conn.Close()
+		}()
+	})
+}
@@ -0,0 +1,35 @@
+package go_websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowConsumerDisconnectDoesNotBlock(t *testing.T) {
+	cm := NewClientManage(
+		WithSendBufferSize(8),
+		WithWriteDeadline(3*time.Second),
+		WithSlowConsumerPolicy(SlowConsumerDisconnect, 0),
+	)
+	u := newTestServer(t, cm)
+	//不读取，写循环阻塞在写入上
+	dialClient(t, cm, u)
+
+	msg, _ := NewOkClientRes(strings.Repeat("x", 1<<20)).GetBytes()
+	deadline := time.Now().Add(10 * time.Second)
+	for cm.GetSlowConsumerStats().Disconnected == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("queue never filled")
+		}
+		start := time.Now()
+		cm.Broadcast(msg)
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("Broadcast blocked %v", d)
+		}
+	}
+
+	waitFor(t, 6*time.Second, func() bool {
+		return cm.GetClientCount() == 0
+	})
+}
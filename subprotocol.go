@@ -0,0 +1,13 @@
+package go_websocket
+
+// 响应编码方法
+type ResponseEncodeFunc func(c *Client, res IResponse) ([]byte, error)
+
+// 子协议，协商成功后决定该客户端的请求、响应编解码
+type Subprotocol struct {
+	Name        string             //子协议名，对应 Sec-WebSocket-Protocol
+	ReqFormatFn RequestFormatFunc  //请求格式化方法，为空时使用管理器配置
+	ResFormatFn ResponseFormatFunc //响应格式化方法，为空时使用管理器配置
+	ResEncodeFn ResponseEncodeFunc //响应编码方法，为空时使用 IResponse.GetBytes
+	Codec       Codec              //编解码器，不为空时忽略以上方法
+}
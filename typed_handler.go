@@ -0,0 +1,86 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// 可注册路由的对象
+type Registrar interface {
+	Register(key string, fn HandlerFunc, middlewares ...Middleware) *Route
+	registerRaw(key string, fn HandlerFunc, middlewares ...Middleware) *Route
+}
+
+// 带类型的处理方法
+type TypedHandlerFunc[Req any, Res any] func(ctx context.Context, client *Client, req Req) (Res, error)
+
+// 注册带类型的处理方法，参数自动解码到 Req 并按 validate 标签校验，失败时返回400
+//
+// Res 实现了 IResponse 时直接返回，否则包装为 NewOkClientRes(res)
+func Handle[Req any, Res any](r Registrar, key string, fn TypedHandlerFunc[Req, Res], middlewares ...Middleware) *Route {
+	return r.registerRaw(key, func(ctx context.Context, client *Client, params interface{}) (IResponse, error) {
+		var req Req
+		if err := client.DecodeParams(params, &req); err != nil {
+			return nil, ErrBadRequest.WithMsg("invalid params").WithDetails(err.Error())
+		}
+
+		if v := client.clientManage.opts.Validator; v != nil {
+			if err := v.Validate(req); err != nil {
+				var verrs ValidationErrors
+				if !errors.As(err, &verrs) {
+					//规则配置错误等不是参数的问题
+					return nil, err
+				}
+				return nil, ErrBadRequest.WithMsg("validation failed").WithDetails(verrs)
+			}
+		}
+
+		res, err := fn(ctx, client, req)
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := any(res).(IResponse); ok {
+			//nil 指针的响应按不响应处理，和普通处理方法返回 nil 一致
+			if isNilValue(reflect.ValueOf(r)) {
+				return nil, nil
+			}
+			return r, nil
+		}
+		return NewOkClientRes(res), nil
+	}, middlewares...)
+}
+
+// 是否为 nil 的指针、map、切片等
+func isNilValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// 参数解码，支持原始JSON和已解析的值
+func DecodeParams(params interface{}, v interface{}) error {
+	switch p := params.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		if len(p) == 0 || string(p) == "null" {
+			return nil
+		}
+		return json.Unmarshal(p, v)
+	case []byte:
+		if len(p) == 0 {
+			return nil
+		}
+		return json.Unmarshal(p, v)
+	default:
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+}
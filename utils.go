@@ -1,6 +1,7 @@
 package go_websocket
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -65,3 +66,34 @@ func InetAtoN(ip string) int64 {
 	ret.SetBytes(net.ParseIP(ip).To4())
 	return ret.Int64()
 }
+
+// 获取网卡IPv4地址，name为空时取第一个非回环网卡
+func GetInterfaceIP(name string) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if name != "" && iface.Name != name {
+			continue
+		}
+		if iface.Flags&net.FlagUp == 0 || (name == "" && iface.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			return ipNet.IP.String(), nil
+		}
+	}
+	if name != "" {
+		return "", fmt.Errorf("interface %s has no ipv4 address", name)
+	}
+	return "", errors.New("no available interface ipv4 address")
+}
@@ -0,0 +1,182 @@
+package go_websocket
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 参数校验
+type Validator interface {
+	Validate(v interface{}) error
+}
+
+// 字段校验错误
+type FieldError struct {
+	Field string `json:"field"` //字段名，优先使用json标签
+	Tag   string `json:"tag"`   //校验规则
+	Param string `json:"param,omitempty"`
+}
+
+func (e FieldError) Error() string {
+	if e.Param != "" {
+		return fmt.Sprintf("%s failed on %s=%s", e.Field, e.Tag, e.Param)
+	}
+	return fmt.Sprintf("%s failed on %s", e.Field, e.Tag)
+}
+
+// 校验错误列表
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	list := make([]string, 0, len(e))
+	for _, fe := range e {
+		list = append(list, fe.Error())
+	}
+	return strings.Join(list, "; ")
+}
+
+// 基于 validate 标签的校验，多个规则用逗号分隔
+//
+// omitempty 为零值时跳过后面的规则
+// required 不能为零值
+// min=N、max=N 数字比较大小，字符串、切片、map比较长度
+// len=N 字符串、切片、map的长度
+// oneof=a b c 取值必须是其中之一
+// 嵌套结构体会递归校验，未知规则返回错误
+type TagValidator struct {
+	TagName string
+}
+
+func NewTagValidator() *TagValidator {
+	return &TagValidator{TagName: "validate"}
+}
+
+func (tv *TagValidator) Validate(v interface{}) error {
+	var errs ValidationErrors
+	if err := tv.validateValue(reflect.ValueOf(v), "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (tv *TagValidator) validateValue(rv reflect.Value, prefix string, errs *ValidationErrors) error {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		name := prefix + fieldName(sf)
+
+		if tag := sf.Tag.Get(tv.TagName); tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				rule = strings.TrimSpace(rule)
+				if rule == "" {
+					continue
+				}
+				ruleName, param, _ := strings.Cut(rule, "=")
+				if ruleName == "omitempty" {
+					if fv.IsZero() {
+						break
+					}
+					continue
+				}
+				ok, err := checkRule(fv, ruleName, param)
+				if err != nil {
+					return fmt.Errorf("validate %s: %w", name, err)
+				}
+				if !ok {
+					*errs = append(*errs, FieldError{Field: name, Tag: ruleName, Param: param})
+				}
+			}
+		}
+
+		if err := tv.validateValue(fv, name+".", errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("json"); tag != "" {
+		if n, _, _ := strings.Cut(tag, ","); n != "" && n != "-" {
+			return n
+		}
+	}
+	return sf.Name
+}
+
+func checkRule(fv reflect.Value, rule string, param string) (bool, error) {
+	switch rule {
+	case "required":
+		return !fv.IsZero(), nil
+	case "min", "max", "len", "oneof":
+	default:
+		return false, fmt.Errorf("unknown rule %q", rule)
+	}
+
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			//未设置的可选字段不校验
+			return true, nil
+		}
+		fv = fv.Elem()
+	}
+
+	switch rule {
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s param %q", rule, param)
+		}
+		var val float64
+		switch fv.Kind() {
+		case reflect.String:
+			val = float64(len([]rune(fv.String())))
+		case reflect.Slice, reflect.Array, reflect.Map:
+			val = float64(fv.Len())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val = float64(fv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			val = float64(fv.Uint())
+		case reflect.Float32, reflect.Float64:
+			val = fv.Float()
+		default:
+			return false, nil
+		}
+		switch rule {
+		case "min":
+			return val >= n, nil
+		case "max":
+			return val <= n, nil
+		default:
+			return val == n, nil
+		}
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, opt := range strings.Fields(param) {
+			if s == opt {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package go_websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTagValidator(t *testing.T) {
+	type optional struct {
+		Name string `json:"name" validate:"omitempty,min=3"`
+	}
+	type required struct {
+		Name string `json:"name" validate:"required,min=2,max=4"`
+		Role string `json:"role" validate:"oneof=user admin"`
+		Age  *int   `json:"age" validate:"min=18"`
+	}
+	age := func(n int) *int { return &n }
+
+	tests := []struct {
+		name string
+		v    interface{}
+		tags []string
+	}{
+		{"omitempty zero", optional{}, nil},
+		{"omitempty set", optional{Name: "ab"}, []string{"min"}},
+		{"omitempty valid", optional{Name: "abc"}, nil},
+		{"valid", required{Name: "abc", Role: "user"}, nil},
+		{"required", required{Role: "user"}, []string{"required", "min"}},
+		{"max", required{Name: "abcde", Role: "admin"}, []string{"max"}},
+		{"oneof", required{Name: "abc", Role: "root"}, []string{"oneof"}},
+		{"nil pointer", required{Name: "abc", Role: "user", Age: nil}, nil},
+		{"pointer", required{Name: "abc", Role: "user", Age: age(17)}, []string{"min"}},
+	}
+	tv := NewTagValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tv.Validate(tt.v)
+			if len(tt.tags) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Validate: %v", err)
+			}
+			if len(errs) != len(tt.tags) {
+				t.Fatalf("got %v, want %v", errs, tt.tags)
+			}
+			for i, fe := range errs {
+				if fe.Tag != tt.tags[i] {
+					t.Fatalf("got %v, want %v", errs, tt.tags)
+				}
+			}
+		})
+	}
+}
+
+func TestTagValidatorUnknownRule(t *testing.T) {
+	type typo struct {
+		Name string `validate:"requird"`
+	}
+	type badParam struct {
+		Name string `validate:"min=x"`
+	}
+	tv := NewTagValidator()
+	for _, v := range []interface{}{typo{}, badParam{Name: "a"}} {
+		err := tv.Validate(v)
+		var errs ValidationErrors
+		if err == nil || errors.As(err, &errs) {
+			t.Fatalf("Validate(%T): %v", v, err)
+		}
+	}
+}
+
+func TestHandleNilResponse(t *testing.T) {
+	h := NewClientHandler()
+	Handle(h, "/nil", func(ctx context.Context, c *Client, req struct{}) (*ClientResponse, error) {
+		return nil, nil
+	})
+	Handle(h, "/ok", func(ctx context.Context, c *Client, req struct{}) (*ClientResponse, error) {
+		return NewOkClientRes("ok"), nil
+	})
+	cm := NewClientManage(WithClientHandler(h))
+	u := newTestServer(t, cm)
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","url":"/nil"}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"2","url":"/ok"}`))
+
+	//nil 响应不回复，第一条收到的是 /ok 的回复，连接保持正常
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res ClientResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Id != "2" || res.Code != 200 {
+		t.Fatalf("unexpected response %s", data)
+	}
+}
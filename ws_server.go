@@ -1,66 +1,245 @@
 package go_websocket
 
 import (
-	"github.com/bwmarrin/snowflake"
 	"github.com/gorilla/websocket"
 	"net/http"
+	"time"
 )
 
-var (
-	snowflakeNode *snowflake.Node
-	wsUpgrader    = &websocket.Upgrader{
+// 升级器配置
+type UpgraderConfig struct {
+	ReadBufferSize    int                        //读缓冲区大小
+	WriteBufferSize   int                        //写缓冲区大小
+	HandshakeTimeout  time.Duration              //握手超时
+	AllowedOrigins    []string                   //允许的Origin，为空时只允许同源
+	CheckOrigin       func(r *http.Request) bool //自定义Origin检查，优先于 AllowedOrigins
+	Subprotocols      []Subprotocol              //支持的子协议，按顺序优先
+	EnableCompression bool                       //是否协商 permessage-deflate 压缩
+}
+
+// 默认升级器配置
+func DefaultUpgraderConfig() UpgraderConfig {
+	return UpgraderConfig{
 		ReadBufferSize:  ReadBufferSize,
 		WriteBufferSize: WriteBufferSize,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
+	}
+}
+
+// 升级器
+type Upgrader struct {
+	config   UpgraderConfig
+	upgrader *websocket.Upgrader
+}
+
+func NewUpgrader(config UpgraderConfig) *Upgrader {
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		if len(config.AllowedOrigins) > 0 {
+			checkOrigin = NewOriginChecker(config.AllowedOrigins...)
+		} else {
+			checkOrigin = CheckSameOrigin
+		}
+	}
+
+	subprotocols := make([]string, 0, len(config.Subprotocols))
+	for _, sp := range config.Subprotocols {
+		subprotocols = append(subprotocols, sp.Name)
+	}
+
+	return &Upgrader{
+		config: config,
+		upgrader: &websocket.Upgrader{
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			HandshakeTimeout:  config.HandshakeTimeout,
+			CheckOrigin:       checkOrigin,
+			Subprotocols:      subprotocols,
+			EnableCompression: config.EnableCompression,
 		},
 	}
-)
+}
 
-func init() {
-	ip, err := GetExternalIP()
-	if err != nil {
-		panic(err)
+// 根据名称查找子协议
+func (u *Upgrader) GetSubprotocol(name string) *Subprotocol {
+	if name == "" {
+		return nil
 	}
-	n := InetAtoN(ip)
-	snowflakeNode, err = snowflake.NewNode(n % 1023)
-	if err != nil {
-		panic(err)
+	for i := range u.config.Subprotocols {
+		if u.config.Subprotocols[i].Name == name {
+			return &u.config.Subprotocols[i]
+		}
 	}
+	return nil
 }
 
-func Upgrade(clientManage *ClientManage, w http.ResponseWriter, r *http.Request) (*Client, error) {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return nil, err
+// 升级器配置
+func (u *Upgrader) GetConfig() UpgraderConfig {
+	return u.config
+}
+
+// 升级连接并注册到管理器
+func (u *Upgrader) Upgrade(clientManage *ClientManage, w http.ResponseWriter, r *http.Request) (*Client, error) {
+	if clientManage.IsClosed() {
+		http.Error(w, ErrManageClosed.Error(), http.StatusServiceUnavailable)
+		return nil, ErrManageClosed
+	}
+
+	//鉴权
+	var identity *Identity
+	if auth := clientManage.opts.Authenticator; auth != nil {
+		id, err := auth.Authenticate(r)
+		if err != nil {
+			writeAuthError(w, err)
+			return nil, err
+		}
+		if id == nil {
+			writeAuthError(w, ErrUnauthorized)
+			return nil, ErrUnauthorized
+		}
+		identity = id
 	}
 
 	systemId := r.FormValue("system_id")
 	group := r.FormValue("group")
 
+	//鉴权后系统由身份决定，组必须在允许范围内
+	if identity != nil {
+		systemId = identity.SystemId
+		if len(group) > 0 && !identity.AllowGroup(group) {
+			err := NewAuthError(http.StatusForbidden, "group not allowed")
+			writeAuthError(w, err)
+			return nil, err
+		}
+	}
+
 	if systemId == "" {
 		systemId = RemoteIp(r)
 	}
 
-	//生成客户端ID
-	clientId := GenerateClientId()
+	//通过查询参数选择编解码器，协商的子协议配置了编解码器时以子协议为准
+	var codec Codec
+	if q := clientManage.opts.CodecQuery; len(q) > 0 {
+		if name := r.URL.Query().Get(q); len(name) > 0 {
+			c, ok := findCodec(clientManage.opts.Codecs, name)
+			if !ok {
+				http.Error(w, ErrCodecNotFound.Error(), http.StatusBadRequest)
+				return nil, ErrCodecNotFound
+			}
+			codec = c
+		}
+	}
+
+	//带会话令牌时认领等待恢复的会话
+	var resume *session
+	if clientManage.opts.ResumeWindow > 0 {
+		if token := r.URL.Query().Get(ResumeTokenQuery); len(token) > 0 {
+			resume = clientManage.claimSession(token, identity)
+		}
+	}
+
+	//协商压缩时统计实际写出的字节数
+	var crw *countingResponseWriter
+	if u.config.EnableCompression && offersCompression(r) {
+		crw = &countingResponseWriter{ResponseWriter: w}
+		w = crw
+	}
+
+	conn, err := u.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if resume != nil {
+			clientManage.releaseSession(resume)
+		}
+		return nil, err
+	}
+
+	//生成客户端ID，恢复会话时沿用原客户端的ID、系统、身份和组
+	clientId := clientManage.opts.IDGenerator.Generate()
+	if resume != nil {
+		old := resume.client
+		clientId = old.GetID()
+		systemId = old.GetSystemId()
+		if identity == nil {
+			identity = old.GetIdentity()
+		}
+	}
 
 	//创建客户端
 	wsClient := NewClient(clientId, systemId, conn, clientManage)
+	wsClient.SetIdentity(identity)
+	wsClient.subprotocol = u.GetSubprotocol(conn.Subprotocol())
+	wsClient.codec = codec
+	if crw != nil && crw.conn != nil {
+		wsClient.wire = crw.conn
+		wsClient.compress = 1
+		if err := conn.SetCompressionLevel(clientManage.opts.CompressionLevel); err != nil {
+			Log.Error(wsClient.ctx, "SetCompressionLevel Error ", err)
+		}
+	}
+	if wsClient.subprotocol != nil && wsClient.subprotocol.Codec != nil {
+		wsClient.codec = wsClient.subprotocol.Codec
+	}
 
+	if resume != nil {
+		wsClient.AddGroup(resume.client.GetGroups()...)
+	}
 	if len(group) > 0 {
 		clientManage.AddGroupsByClient(wsClient, group)
 	}
 
-	//添加客户端
-	clientManage.Register(wsClient)
+	//离线消息补发完前暂存实时消息，保证按顺序
+	if clientManage.opts.MessageStore != nil {
+		wsClient.holdLiveMsg()
+	}
 
-	go wsClient.ReadLoop()
-	go wsClient.WriteLoop()
+	//恢复会话或添加客户端，启动读写循环，升级过程中管理器关闭时直接断开
+	if err := u.register(clientManage, wsClient, resume, parseLastSeq(r.URL.Query().Get(LastSeqQuery))); err != nil {
+		clientManage.UnRegister(wsClient)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(clientManage.opts.CloseCode, clientManage.opts.CloseReason),
+			time.Now().Add(clientManage.opts.WriteDeadline))
+		conn.Close()
+		return nil, err
+	}
+
+	//补发离线消息
+	clientManage.replayOffline(wsClient)
 
 	return wsClient, nil
 }
 
+// 注册并启动客户端，开启会话恢复时创建会话并推送会话信息
+func (u *Upgrader) register(cm *ClientManage, c *Client, resume *session, lastSeq uint64) error {
+	if resume != nil {
+		return cm.resumeSession(resume, c, lastSeq)
+	}
+	if cm.opts.ResumeWindow <= 0 {
+		cm.Register(c)
+		return cm.startClient(c)
+	}
+
+	//持有会话锁直到会话信息入队，保证会话信息是第一条消息
+	s, err := cm.newSession(c)
+	if err != nil {
+		return err
+	}
+	defer s.lock.Unlock()
+
+	cm.Register(c)
+	if err := cm.startClient(c); err != nil {
+		return err
+	}
+	return c.sendSessionInfo(SessionInfo{
+		Token:    s.token,
+		ClientId: c.GetID(),
+	})
+}
+
+// 使用管理器的升级器升级连接
+func Upgrade(clientManage *ClientManage, w http.ResponseWriter, r *http.Request) (*Client, error) {
+	return clientManage.GetUpgrader().Upgrade(clientManage, w, r)
+}
+
+// 使用默认ID生成器生成客户端ID
 func GenerateClientId() string {
-	return snowflakeNode.Generate().String()
+	return DefaultIDGenerator().Generate()
 }